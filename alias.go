@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// aliasNamePattern matches valid alias names, which are entered after an @.
+var aliasNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// commandAliases maps alias names to the commands they run in order, with
+// the aliases they use already expanded.
+var commandAliases map[string][]string
+
+// setCommandAliases sets the aliases from name=command definitions, where
+// the commands of definitions with the same name are run in the order
+// given. A command may be @name to run another alias, as long as no alias
+// ends up running itself.
+func setCommandAliases(defs [][2]string) error {
+	raw := make(map[string][]string)
+	var names []string
+	for _, def := range defs {
+		name, command := def[0], strings.TrimSpace(def[1])
+		if !aliasNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid alias name %q: must be letters, digits, '_', and '-'", name)
+		}
+		if command == "" {
+			return fmt.Errorf("alias @%s has an empty command", name)
+		}
+		if _, ok := raw[name]; !ok {
+			names = append(names, name)
+		}
+		raw[name] = append(raw[name], command)
+	}
+
+	aliases := make(map[string][]string)
+	var expand func(name string, path []string) ([]string, error)
+	expand = func(name string, path []string) ([]string, error) {
+		for i, seen := range path {
+			if seen == name {
+				return nil, fmt.Errorf("alias @%s runs itself: @%s", name, strings.Join(append(path[i:], name), " -> @"))
+			}
+		}
+		if commands, ok := aliases[name]; ok {
+			return commands, nil
+		}
+
+		var commands []string
+		for _, command := range raw[name] {
+			ref, ok := strings.CutPrefix(command, "@")
+			if !ok {
+				commands = append(commands, command)
+				continue
+			}
+			if _, ok := raw[ref]; !ok {
+				return nil, fmt.Errorf("alias @%s runs undefined alias @%s", name, ref)
+			}
+			expanded, err := expand(ref, append(path, name))
+			if err != nil {
+				return nil, err
+			}
+			commands = append(commands, expanded...)
+		}
+		aliases[name] = commands
+
+		return commands, nil
+	}
+	for _, name := range names {
+		if _, err := expand(name, nil); err != nil {
+			return err
+		}
+	}
+
+	commandAliases = aliases
+	return nil
+}
+
+// expandAlias returns the commands that a line of input runs: those of
+// the alias if it is an @alias, and otherwise the line itself.
+func expandAlias(line string) ([]string, error) {
+	name, ok := strings.CutPrefix(strings.TrimSpace(line), "@")
+	if !ok {
+		return []string{line}, nil
+	}
+	if strings.ContainsAny(name, " \t") {
+		return nil, fmt.Errorf("aliases take no arguments: %q", line)
+	}
+
+	commands, ok := commandAliases[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown alias @%s", name)
+	}
+
+	return commands, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxAPICommandSize bounds the body of a command sent to the API.
+const maxAPICommandSize = 4096
+
+// apiStatus is the response to GET /status.
+type apiStatus struct {
+	Up            bool     `json:"up"`
+	Version       string   `json:"version,omitempty"`
+	MOTD          string   `json:"motd,omitempty"`
+	PlayersOnline int      `json:"players_online"`
+	PlayersMax    int      `json:"players_max"`
+	Players       []string `json:"players"`
+	Restarts      int      `json:"restarts"`
+	Started       string   `json:"started,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// startAPIServer serves the control API on the given address, requiring the
+// given bearer token of every request. Commands are sent to the server over
+// RCON if it is configured, so that their output can be returned, and to
+// its console otherwise, with an @alias running each of its commands in
+// order. stop stops the wrapper, as a stop signal would. Console commands and
+// restarts are refused while no server is running, such as while it sleeps
+// until a player joins.
+func startAPIServer(addr, token string, srv *server, stop func()) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/command", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAPICommandSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		command := strings.TrimSpace(string(body))
+		if command == "" || strings.ContainsAny(command, "\r\n") {
+			http.Error(w, "the body must be a single command", http.StatusBadRequest)
+			return
+		}
+		log.Printf("api: %s sent command %q", r.RemoteAddr, command)
+		commands, err := expandAlias(command)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !srv.rconConfigured() {
+			switch err := srv.console.trySend(commands...); {
+			case errors.Is(err, errNotRunning):
+				http.Error(w, err.Error(), http.StatusConflict)
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			default:
+				w.WriteHeader(http.StatusAccepted)
+			}
+			return
+		}
+
+		client, err := srv.dialRCON()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer client.Close()
+
+		// The responses to an alias's commands are returned one per line.
+		var responses []string
+		for _, command := range commands {
+			response, err := client.command(command)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			responses = append(responses, response)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, strings.Join(responses, "\n"))
+	})
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		log.Printf("api: %s requested a stop, stopping", r.RemoteAddr)
+		w.WriteHeader(http.StatusAccepted)
+		stop()
+	})
+	mux.HandleFunc("/restart", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		select {
+		case srv.restartRequests <- struct{}{}:
+			log.Printf("api: %s requested a restart", r.RemoteAddr)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			// The server is asleep, between starts, or already restarting.
+			http.Error(w, "the server isn't running", http.StatusConflict)
+		}
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(srv.apiStatus())
+	})
+
+	api := &http.Server{
+		Handler:           requireToken(token, mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go api.Serve(ln)
+
+	return api, nil
+}
+
+// apiStatus pings the server and reports its status with that tracked by
+// the wrapper.
+func (s *server) apiStatus() apiStatus {
+	ping, err := ping(serverHost(s.dir), serverPort(s.dir))
+
+	s.metrics.mu.Lock()
+	status := apiStatus{
+		Up:       err == nil,
+		Version:  s.version,
+		Players:  s.metrics.players(),
+		Restarts: s.metrics.restarts,
+	}
+	if !s.metrics.started.IsZero() {
+		status.Started = s.metrics.started.UTC().Format(time.RFC3339)
+	}
+	s.metrics.mu.Unlock()
+
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.MOTD = ping.MOTD
+		status.PlayersOnline = ping.Players.Online
+		status.PlayersMax = ping.Players.Max
+		if status.Version == "" {
+			status.Version = ping.Version.Name
+		}
+	}
+
+	return status
+}
+
+// allowMethod replies with 405 Method Not Allowed and reports false if the
+// request doesn't use the given method.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// requireToken rejects requests to the handler that don't carry the given
+// bearer token, slowing down guessing as the TCP console does.
+func requireToken(token string, next http.Handler) http.Handler {
+	limiter := newAuthLimiter()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		done, ok := limiter.attempt(host)
+		if !ok {
+			http.Error(w, "too many failed or pending requests", http.StatusTooManyRequests)
+			return
+		}
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			log.Printf("api: request from %s failed to authenticate", r.RemoteAddr)
+			time.Sleep(consoleAuthDelay)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			done(false)
+			return
+		}
+		done(true)
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backupPrefix and backupSuffix surround the timestamp in backup archive names.
+const (
+	backupPrefix = "world-"
+	backupSuffix = ".tar.gz"
+)
+
+// runBackup backs up the server's world into its backup directory and
+// prunes old backups so that at most backupKeep remain. It returns the path
+// of the backup, or an empty path if there was no world to back up.
+func (s *server) runBackup() (string, error) {
+	dir := s.dir
+	if dir == "" {
+		dir = "."
+	}
+
+	name, err := backupWorlds(dir, s.backupDir)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		s.logf("no world to back up yet")
+	} else {
+		s.logf("backed up world to %s", name)
+		s.metrics.setLastBackup(time.Now())
+	}
+
+	return name, pruneBackups(s.backupDir, s.backupKeep)
+}
+
+// goodBackupSuffix is appended to a backup's name to name the marker file
+// recording that the backup holds a world the server was seen to load.
+const goodBackupSuffix = ".good"
+
+// markBackupGood records that the given backup holds a world the server
+// loaded, which makes it one that -auto-restore may restore.
+func (s *server) markBackupGood(backup string) {
+	if err := os.WriteFile(backup+goodBackupSuffix, nil, 0644); err != nil {
+		s.logf("warning: failed to mark backup %s as good, so it won't be restored: %v", backup, err)
+	}
+}
+
+// isGoodBackup reports whether the given backup has been marked good.
+func isGoodBackup(backup string) bool {
+	_, err := os.Stat(backup + goodBackupSuffix)
+	return err == nil
+}
+
+// runLiveBackup backs up the world of the running server. Saving is paused
+// over RCON during the backup so that the world is consistent on disk.
+func (s *server) runLiveBackup() error {
+	if !s.rconConfigured() {
+		s.logf("warning: rcon isn't configured, backing up without pausing saves so the backup may be inconsistent")
+		_, err := s.runBackup()
+		return err
+	}
+
+	client, err := s.dialRCON()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if _, err := client.command("save-off"); err != nil {
+		return err
+	}
+
+	// Always turn saving back on, even if the backup fails.
+	defer func() {
+		if _, err := client.command("save-on"); err != nil {
+			s.logf("failed to turn saving back on: %v", err)
+		}
+	}()
+
+	if _, err := client.command("save-all flush"); err != nil {
+		return err
+	}
+
+	// The server saved its world on request, so it has loaded it.
+	name, err := s.runBackup()
+	if err == nil && name != "" {
+		s.markBackupGood(name)
+	}
+	return err
+}
+
+// worldDirs returns the names of the world directories of the server in the
+// given directory, based on the level-name in server.properties.
+func worldDirs(serverDir string) []string {
+	name := "world"
+	if p, err := loadProperties(filepath.Join(serverDir, "server.properties")); err == nil {
+		if v, ok := p.get("level-name"); ok && v != "" {
+			name = v
+		}
+	}
+
+	return []string{name, name + "_nether", name + "_the_end"}
+}
+
+// backupWorlds archives the existing world directories of the server in
+// serverDir into a timestamped archive in backupDir, returning its path. It
+// returns an empty path if there are no worlds to back up yet.
+func backupWorlds(serverDir, backupDir string) (string, error) {
+	var worlds []string
+	for _, dir := range worldDirs(serverDir) {
+		if info, err := os.Stat(filepath.Join(serverDir, dir)); err == nil && info.IsDir() {
+			worlds = append(worlds, dir)
+		}
+	}
+	if len(worlds) == 0 {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return "", err
+	}
+
+	// Number a backup taken in the same second as another, so that it sorts
+	// after it rather than replacing it.
+	stamp := time.Now().Format("20060102-150405")
+	for n := 1; ; n++ {
+		name := filepath.Join(backupDir, backupPrefix+stamp+backupSuffix)
+		if n > 1 {
+			name = filepath.Join(backupDir, backupPrefix+stamp+"_"+strconv.Itoa(n)+backupSuffix)
+		}
+		if _, err := os.Lstat(name); err == nil {
+			continue
+		}
+
+		err := writeArchive(name, serverDir, worlds)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+}
+
+// writeArchive writes the given directories, relative to base, into a
+// gzipped tar archive with the given filename. The archive is written to a
+// temporary file that is only renamed into place once complete, so that an
+// interrupted backup never looks like a real one. It fails with an error
+// satisfying errors.Is(err, fs.ErrExist) if another backup is being written
+// to the same filename.
+func writeArchive(filename, base string, dirs []string) (err error) {
+	tmp := filename + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	for _, dir := range dirs {
+		if err := filepath.Walk(filepath.Join(base, dir), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			return addToArchive(tw, path, name, info)
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Close the writers in order so all data is flushed.
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+// addToArchive writes a single file or directory to the given tar writer
+// under the given name.
+func addToArchive(tw *tar.Writer, path, name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Copy only the size in the header, as the server may still be appending
+	// to the file without saving being paused.
+	if _, err := io.CopyN(tw, file, header.Size); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// listBackups returns the paths of the backup archives in the given directory, oldest first.
+func listBackups(backupDir string) ([]string, error) {
+	entries, err := os.ReadDir(backupDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The timestamp format sorts chronologically by name.
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, filepath.Join(backupDir, name))
+		}
+	}
+	sort.Strings(backups)
+
+	return backups, nil
+}
+
+// pruneBackups removes the oldest backups in the given directory so that at
+// most keep remain. A keep of zero or less keeps every backup.
+func pruneBackups(backupDir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	backups, err := listBackups(backupDir)
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > keep {
+		log.Printf("removing old backup %s", backups[0])
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		os.Remove(backups[0] + goodBackupSuffix)
+		backups = backups[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupWorldsKeepsEachBackup(t *testing.T) {
+	serverDir, backupDir := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(serverDir, "world", "region"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(serverDir, "world", "region", "r.0.0.mca"), []byte("region"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Backups taken within the same second mustn't replace each other.
+	var names []string
+	for range 3 {
+		name, err := backupWorlds(serverDir, backupDir)
+		if err != nil {
+			t.Fatalf("backupWorlds: %v", err)
+		}
+		names = append(names, name)
+	}
+
+	backups, err := listBackups(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != len(names) {
+		t.Fatalf("listBackups = %q, want %q", backups, names)
+	}
+	for i := range names {
+		if backups[i] != names[i] {
+			t.Errorf("backup %d is %s, want %s in the order taken", i, backups[i], names[i])
+		}
+	}
+
+	// Only the finished archives are left behind.
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(names) {
+		t.Errorf("backup directory has %d entries, want %d", len(entries), len(names))
+	}
+}
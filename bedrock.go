@@ -0,0 +1,248 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// bedrockLinksAPI lists the download links of Minecraft's current releases,
+// including those of the Bedrock dedicated server.
+const bedrockLinksAPI = "https://net-secondary.web.minecraft-services.net/api/v1.0/download/links"
+
+// bedrockDownloadBase is the base URL of the Bedrock dedicated server zips,
+// which are named after their version.
+const bedrockDownloadBase = "https://www.minecraft.net/bedrockdedicatedserver"
+
+// bedrockDownloadDirs are the directories of the release zips for each
+// platform under bedrockDownloadBase.
+var bedrockDownloadDirs = map[string]string{"Linux": "bin-linux", "Windows": "bin-win"}
+
+// bedrockInstalledFile records the checksum of the zip last extracted into
+// a Bedrock server's directory, so that it is only extracted again once the
+// zip changes.
+const bedrockInstalledFile = ".bedrock-installed"
+
+// bedrockZipPattern matches the version in the name of a Bedrock dedicated
+// server zip, e.g. bedrock-server-1.21.44.01.zip.
+var bedrockZipPattern = regexp.MustCompile(`/bedrock-server-([0-9][0-9.]*)\.zip$`)
+
+// bedrockConfigFiles are the files of a Bedrock server that the zip ships
+// defaults of, and that are kept rather than overwritten once they exist.
+var bedrockConfigFiles = map[string]bool{
+	"server.properties": true,
+	"permissions.json":  true,
+	"allowlist.json":    true,
+	"whitelist.json":    true,
+}
+
+// bedrockResolver resolves versions of the Bedrock dedicated server for the
+// current platform from Minecraft's download links. Mojang publishes no
+// checksums for it.
+type bedrockResolver struct{}
+
+// Resolve resolves 'release' and 'snapshot' to the current release and
+// preview of the dedicated server, and a specific version to its zip.
+func (bedrockResolver) Resolve(ctx context.Context, id string) (resolvedVersion, error) {
+	// bedrockLinks contains the parsed JSON from the download links.
+	type bedrockLinks struct {
+		Result struct {
+			Links []struct {
+				DownloadType string
+				DownloadURL  string
+			}
+		}
+	}
+
+	platform, err := bedrockPlatform()
+	if err != nil {
+		return resolvedVersion{}, err
+	}
+
+	if id != "release" && id != "snapshot" {
+		if !bedrockZipPattern.MatchString("/bedrock-server-" + id + ".zip") {
+			return resolvedVersion{}, errors.New("invalid version")
+		}
+		return resolvedVersion{
+			Distribution: "bedrock",
+			ID:           id,
+			Type:         "release",
+			URL:          fmt.Sprintf("%s/%s/bedrock-server-%s.zip", bedrockDownloadBase, bedrockDownloadDirs[platform], id),
+		}, nil
+	}
+
+	downloadType, versionType := "serverBedrock"+platform, "release"
+	if id == "snapshot" {
+		downloadType, versionType = "serverBedrockPreview"+platform, "snapshot"
+	}
+
+	var links bedrockLinks
+	if err := getJSON(ctx, bedrockLinksAPI, &links); err != nil {
+		return resolvedVersion{}, err
+	}
+	for _, link := range links.Result.Links {
+		if link.DownloadType != downloadType {
+			continue
+		}
+		m := bedrockZipPattern.FindStringSubmatch(link.DownloadURL)
+		if m == nil {
+			return resolvedVersion{}, fmt.Errorf("failed to parse the version from the bedrock download %s", link.DownloadURL)
+		}
+		return resolvedVersion{
+			Distribution: "bedrock",
+			ID:           m[1],
+			Type:         versionType,
+			URL:          link.DownloadURL,
+		}, nil
+	}
+
+	return resolvedVersion{}, fmt.Errorf("no %s download is listed", downloadType)
+}
+
+// bedrockPlatform returns the platform in the names of Bedrock dedicated
+// server downloads for the current OS, which is only built for Linux and
+// Windows.
+func bedrockPlatform() (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return "Linux", nil
+	case "windows":
+		return "Windows", nil
+	default:
+		return "", fmt.Errorf("the bedrock dedicated server is only available for linux and windows, not %s", runtime.GOOS)
+	}
+}
+
+// bedrockExecutable returns the name of the Bedrock dedicated server's
+// executable, as extracted from its zip.
+func bedrockExecutable() string {
+	if runtime.GOOS == "windows" {
+		return "bedrock_server.exe"
+	}
+
+	return "bedrock_server"
+}
+
+// recordedBedrockZip reports whether the zip at filename was recorded as
+// the given version of the Bedrock server and still matches the record. As
+// no checksums are published, a zip is otherwise downloaded on every start.
+func recordedBedrockZip(filename string, version resolvedVersion) (string, bool) {
+	if version.Distribution != "bedrock" || forceDownload {
+		return "", false
+	}
+
+	installed, err := readInstalledVersion(filename)
+	if err != nil || installed.Distribution != "bedrock" || installed.ID != version.ID {
+		return "", false
+	}
+	if verifySHA1(filename, installed.SHA1) != nil {
+		return "", false
+	}
+
+	return installed.SHA1, true
+}
+
+// installBedrock extracts the Bedrock server zip into dir unless it was the
+// last one extracted there. Config files that already exist are kept, so
+// that updating the server doesn't reset its settings.
+func installBedrock(zipFile, dir string) error {
+	sum, err := fileChecksum(zipFile, "sha1")
+	if err != nil {
+		return err
+	}
+	marker := filepath.Join(dir, bedrockInstalledFile)
+	if installed, err := os.ReadFile(marker); err == nil && strings.TrimSpace(string(installed)) == sum {
+		if _, err := os.Stat(filepath.Join(dir, bedrockExecutable())); err == nil {
+			return nil
+		}
+	}
+
+	zr, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", zipFile, err)
+	}
+	defer zr.Close()
+
+	log.Printf("extracting %s into %s", zipFile, filepath.Join(dir, "."))
+	for _, f := range zr.File {
+		if err := extractBedrockFile(f, dir); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+		}
+	}
+
+	return os.WriteFile(marker, []byte(sum+"\n"), 0644)
+}
+
+// extractBedrockFile extracts a single file of the Bedrock server zip into
+// dir, replacing any existing file in a single rename.
+func extractBedrockFile(f *zip.File, dir string) error {
+	if !filepath.IsLocal(f.Name) {
+		return errors.New("path escapes the server directory")
+	}
+	target := filepath.Join(dir, f.Name)
+	if f.FileInfo().IsDir() {
+		return os.MkdirAll(target, 0755)
+	}
+	if bedrockConfigFiles[f.Name] {
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// Keep the executable bit of the server and its scripts.
+	mode := os.FileMode(0644)
+	if f.Mode()&0111 != 0 || f.Name == bedrockExecutable() {
+		mode = 0755
+	}
+
+	tmp := filepath.Join(filepath.Dir(target), ".extract-"+filepath.Base(target))
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, target)
+}
+
+// checkBedrockPortFree returns an error if the UDP port the Bedrock server
+// is configured to listen on in the given properties file is in use.
+func checkBedrockPortFree(filename string) error {
+	addr, err := serverAddr(filename)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		_, port, _ := net.SplitHostPort(addr)
+		return fmt.Errorf("port %s already in use, set a different server-port in %s or stop the process using it: %w", port, filename, err)
+	}
+
+	return conn.Close()
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cleanCandidate is a file the clean subcommand removes.
+type cleanCandidate struct {
+	path   string
+	size   int64
+	reason string
+}
+
+// runClean removes cached jars of versions no longer recently installed,
+// backups past a retention window, and rotated logs. Nothing is removed
+// unless -yes is given, and -dry-run only lists what would be.
+func runClean(args []string) error {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	filename := fs.String("filename", "server.jar", "Filename of the server, whose version.json records the recently installed versions.")
+	dir := fs.String("dir", ".", "Directory of the server.")
+	cacheDir := fs.String("jar-cache-dir", "", "Directory of the jar cache to remove the jars of versions other than the recently installed ones from.")
+	keepVersions := fs.Int("keep-versions", 3, "Number of recently installed versions, including the current one, whose cached jars are kept.")
+	backupDir := fs.String("backup-dir", "", "Directory of the backups to remove those older than -backup-max-age from.")
+	backupMaxAge := fs.Duration("backup-max-age", 0, "Age past which backups are removed, e.g. 720h. Requires -backup-dir.")
+	logFile := fs.String("log-file", "", "Log file whose rotated copies are removed.")
+	logMaxAge := fs.Duration("log-max-age", 0, "Age past which the rotated copies of -log-file and the server's archived logs are removed. Zero removes every rotated copy of -log-file and leaves the server's logs alone.")
+	dryRun := fs.Bool("dry-run", false, "Lists the files that would be removed, without removing them.")
+	yes := fs.Bool("yes", false, "Removes the files. Required unless -dry-run is given.")
+	fs.Parse(args)
+
+	if !*dryRun && !*yes {
+		return errors.New("clean removes files: give -yes to remove them, or -dry-run to list them")
+	}
+	if *backupMaxAge > 0 && *backupDir == "" {
+		return errors.New("-backup-max-age requires -backup-dir")
+	}
+	if *backupDir != "" && *backupMaxAge <= 0 {
+		return errors.New("-backup-dir requires a positive -backup-max-age")
+	}
+	if *keepVersions < 1 {
+		return errors.New("-keep-versions must be at least 1")
+	}
+	if *cacheDir == "" && *backupDir == "" && *logFile == "" && *logMaxAge <= 0 {
+		return errors.New("nothing to clean: give -jar-cache-dir, -backup-dir, -log-file, or -log-max-age")
+	}
+
+	var candidates []cleanCandidate
+	if *cacheDir != "" {
+		found, err := staleCachedJars(*cacheDir, filepath.Join(*dir, *filename), *keepVersions)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, found...)
+	}
+	if *backupDir != "" {
+		found, err := expiredBackups(*backupDir, *backupMaxAge)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, found...)
+	}
+	if *logFile != "" {
+		found, err := rotatedLogs(*logFile, *logMaxAge)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, found...)
+	}
+	if *logMaxAge > 0 {
+		found, err := archivedServerLogs(filepath.Join(*dir, "logs"), *logMaxAge)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, found...)
+	}
+
+	var freed int64
+	var failed int
+	for _, c := range candidates {
+		if *dryRun {
+			fmt.Printf("would remove %s (%s, %s)\n", c.path, formatSize(c.size), c.reason)
+			freed += c.size
+			continue
+		}
+		if err := os.Remove(c.path); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove %s: %v\n", c.path, err)
+			failed++
+			continue
+		}
+		// Remove the marker of a backup that was marked good along with it.
+		os.Remove(c.path + goodBackupSuffix)
+		fmt.Printf("removed %s (%s, %s)\n", c.path, formatSize(c.size), c.reason)
+		freed += c.size
+	}
+
+	if *dryRun {
+		fmt.Printf("%d files, %s would be freed\n", len(candidates), formatSize(freed))
+		return nil
+	}
+	fmt.Printf("%d files removed, %s freed\n", len(candidates)-failed, formatSize(freed))
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d files", failed)
+	}
+
+	return nil
+}
+
+// staleCachedJars returns the jars in the jar cache other than those of the
+// keep most recently installed versions of the given jar, as recorded in its
+// version.json sidecar.
+func staleCachedJars(cacheDir, jar string, keep int) ([]cleanCandidate, error) {
+	installed, err := readInstalledVersion(jar)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("can't clean %s: no %s records the versions installed as %s", cacheDir, installedVersionFile, jar)
+	} else if err != nil {
+		return nil, err
+	}
+
+	recent := map[string]bool{cachedJarName(installed.Distribution, installed.ID): true}
+	for _, v := range installed.History {
+		if len(recent) >= keep {
+			break
+		}
+		recent[cachedJarName(v.Distribution, v.ID)] = true
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stale []cleanCandidate
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "server-") || !strings.HasSuffix(name, ".jar") || recent[name] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		stale = append(stale, cleanCandidate{
+			path:   filepath.Join(cacheDir, name),
+			size:   info.Size(),
+			reason: "not a recently installed version",
+		})
+	}
+
+	return stale, nil
+}
+
+// expiredBackups returns the backups in the given directory taken more than
+// maxAge ago, going by the time in their names.
+func expiredBackups(backupDir string, maxAge time.Duration) ([]cleanCandidate, error) {
+	backups, err := listBackups(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []cleanCandidate
+	for _, backup := range backups {
+		info, err := os.Stat(backup)
+		if err != nil {
+			return nil, err
+		}
+		taken := info.ModTime()
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(backup), backupPrefix), backupSuffix)
+		if t, err := time.ParseInLocation("20060102-150405", stamp, time.Local); err == nil {
+			taken = t
+		}
+		if age := time.Since(taken); age > maxAge {
+			expired = append(expired, cleanCandidate{
+				path:   backup,
+				size:   info.Size(),
+				reason: "taken " + age.Round(time.Minute).String() + " ago",
+			})
+		}
+	}
+
+	return expired, nil
+}
+
+// rotatedLogs returns the rotated copies of the given log file, which are
+// named after it with a numeric suffix, last modified more than maxAge ago.
+// A maxAge of zero returns every rotated copy.
+func rotatedLogs(logFile string, maxAge time.Duration) ([]cleanCandidate, error) {
+	entries, err := os.ReadDir(filepath.Dir(logFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var rotated []cleanCandidate
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), filepath.Base(logFile)+".")
+		if !ok || suffix == "" || strings.Trim(suffix, "0123456789") != "" || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if maxAge > 0 && time.Since(info.ModTime()) <= maxAge {
+			continue
+		}
+		rotated = append(rotated, cleanCandidate{
+			path:   filepath.Join(filepath.Dir(logFile), entry.Name()),
+			size:   info.Size(),
+			reason: "rotated log",
+		})
+	}
+
+	return rotated, nil
+}
+
+// archivedServerLogs returns the logs the server compressed into the given
+// logs directory that were last modified more than maxAge ago.
+func archivedServerLogs(logsDir string, maxAge time.Duration) ([]cleanCandidate, error) {
+	entries, err := os.ReadDir(logsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var archived []cleanCandidate
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log.gz") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if time.Since(info.ModTime()) > maxAge {
+			archived = append(archived, cleanCandidate{
+				path:   filepath.Join(logsDir, entry.Name()),
+				size:   info.Size(),
+				reason: "archived server log",
+			})
+		}
+	}
+
+	return archived, nil
+}
+
+// formatSize formats a number of bytes for people, e.g. 1.5 MiB.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// encodeBody returns body compressed with the given writer.
+func encodeBody(t *testing.T, body string, newWriter func(io.Writer) io.WriteCloser) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	if _, err := io.WriteString(w, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestGetBytesDecodesBody(t *testing.T) {
+	const body = `{"latest":{"release":"1.20.1"}}`
+
+	tests := []struct {
+		name     string
+		encoding string
+		data     []byte
+	}{
+		{"identity", "", []byte(body)},
+		{"explicit identity", "identity", []byte(body)},
+		{"gzip", "gzip", encodeBody(t, body, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })},
+		{"zlib deflate", "deflate", encodeBody(t, body, func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })},
+		{"raw deflate", "deflate", encodeBody(t, body, func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Accept-Encoding"); got != acceptEncoding {
+					t.Errorf("Accept-Encoding = %q, want %q", got, acceptEncoding)
+				}
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Write(tt.data)
+			}))
+			defer srv.Close()
+
+			got, err := getBytes(context.Background(), srv.URL)
+			if err != nil {
+				t.Fatalf("getBytes: %v", err)
+			}
+			if string(got) != body {
+				t.Errorf("getBytes = %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestGetBytesRejectsBadEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		data     []byte
+	}{
+		{"corrupt gzip", "gzip", []byte("not gzip at all")},
+		{"truncated gzip", "gzip", encodeBody(t, "a body long enough to cut short", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })[:20]},
+		{"unsupported", "br", []byte("whatever")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", tt.encoding)
+				w.Write(tt.data)
+			}))
+			defer srv.Close()
+
+			if got, err := getBytes(context.Background(), srv.URL); err == nil {
+				t.Errorf("getBytes = %q, want an error", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+// levelPattern matches the thread and level of a server log line, e.g.
+// "[Server thread/WARN]: ", or the time and level of a Bedrock server log
+// line, e.g. "[2024-01-02 12:34:56:789 WARN] ", wherever it is after any
+// prefixes such as the server's name or the time the line was read.
+var levelPattern = regexp.MustCompile(`\[(?:[^\]]*/([A-Z]+)\]: |\d{4}-\d{2}-\d{2} [\d:]+ ([A-Z]+)\] )`)
+
+// levelColors are the ANSI colors of the levels that are colorized.
+var levelColors = map[string]string{
+	"WARN":  "\x1b[33m",
+	"ERROR": "\x1b[31m",
+	"FATAL": "\x1b[1;31m",
+}
+
+// colorReset ends a colorized line.
+const colorReset = "\x1b[0m"
+
+// useColor reports whether server output written to stdout is colorized for
+// the given -color mode. In auto mode it is if stdout is a terminal, unless
+// NO_COLOR is set or TERM is dumb, and never when output is re-emitted as
+// JSON.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return !jsonLogs, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return !jsonLogs && isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb", nil
+	default:
+		return false, fmt.Errorf("invalid color mode %q: must be 'auto', 'always', or 'never'", mode)
+	}
+}
+
+// colorWriter colorizes the lines written to w by their log level, leaving
+// lines without a colorized level as they are.
+type colorWriter struct {
+	w io.Writer
+}
+
+// Write writes b to the underlying writer in a single call, wrapping each
+// warning or error line in its color.
+func (c colorWriter) Write(b []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		color := ""
+		if m := levelPattern.FindSubmatch(line); m != nil {
+			color = levelColors[string(m[1])+string(m[2])]
+		}
+		if color == "" {
+			buf.Write(line)
+			continue
+		}
+
+		text, newline := bytes.CutSuffix(line, []byte("\n"))
+		buf.WriteString(color)
+		buf.Write(text)
+		buf.WriteString(colorReset)
+		if newline {
+			buf.WriteByte('\n')
+		}
+	}
+
+	if _, err := c.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
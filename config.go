@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// configExcluded are flags that can't be set from a config file.
+var configExcluded = map[string]bool{
+	"config":        true,
+	"write-config":  true,
+	"list-versions": true,
+	"show-version":  true,
+}
+
+// secretEnvVars maps the flags holding secrets to the environment variables
+// they fall back to when empty, so that secrets needn't appear in process
+// listings or shell history.
+var secretEnvVars = map[string]string{
+	"api-token":        "MINECRAFT_API_TOKEN",
+	"console-password": "MINECRAFT_CONSOLE_PASSWORD",
+	"rcon-password":    "MINECRAFT_RCON_PASSWORD",
+	"password":         "MINECRAFT_RCON_PASSWORD",
+	"webhook-url":      "MINECRAFT_WEBHOOK_URL",
+}
+
+// applySecretEnv sets each empty secret flag of fs from its environment
+// variable, if that is set.
+func applySecretEnv(fs *flag.FlagSet) error {
+	for name, env := range secretEnvVars {
+		f := fs.Lookup(name)
+		if f == nil || f.Value.String() != "" {
+			continue
+		}
+		if value := os.Getenv(env); value != "" {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value for %s in %s: %w", name, env, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// multiValue is implemented by flags that may be given more than once.
+type multiValue interface {
+	values() []string
+}
+
+// values returns each collected pair in key=value form.
+func (f *keyValueFlag) values() []string {
+	var pairs []string
+	for _, kv := range *f {
+		pairs = append(pairs, kv[0]+"="+kv[1])
+	}
+
+	return pairs
+}
+
+// values returns each collected file in the url=dest form it was given in.
+func (f *fetchFlag) values() []string {
+	var files []string
+	for _, file := range *f {
+		u := file.URL
+		if file.SHA1 != "" {
+			u += "#sha1=" + file.SHA1
+		}
+		files = append(files, u+"="+filepath.ToSlash(file.Dest))
+	}
+
+	return files
+}
+
+// loadConfig sets the flags of fs from the given JSON or TOML config file,
+// keeping any flags that were already set on the command line.
+func loadConfig(fs *flag.FlagSet, filename string) error {
+	values, err := readConfig(filename)
+	if err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for _, kv := range values {
+		if fs.Lookup(kv[0]) == nil || configExcluded[kv[0]] {
+			return fmt.Errorf("%s: unknown key %q", filename, kv[0])
+		}
+		if set[kv[0]] {
+			continue
+		}
+		if err := fs.Set(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("%s: invalid value for %q: %w", filename, kv[0], err)
+		}
+	}
+
+	return nil
+}
+
+// readConfig returns the key/value pairs in the given config file, which is
+// parsed as TOML if it has a .toml extension and as JSON otherwise. Arrays
+// produce a pair for each element.
+func readConfig(filename string) ([][2]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if filepath.Ext(filename) == ".toml" {
+		return parseTOMLConfig(data)
+	}
+
+	// Keep numbers as written, since large ones such as byte sizes would
+	// otherwise be formatted in exponent form.
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%s: unexpected data after the config object", filename)
+	}
+
+	// Apply the keys in a predictable order.
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var values [][2]string
+	for _, key := range keys {
+		if list, ok := raw[key].([]interface{}); ok {
+			for _, item := range list {
+				values = append(values, [2]string{key, fmt.Sprint(item)})
+			}
+		} else {
+			values = append(values, [2]string{key, fmt.Sprint(raw[key])})
+		}
+	}
+
+	return values, nil
+}
+
+// parseTOMLConfig parses the flat subset of TOML used by config files:
+// top-level keys with string, number, boolean, or single-line array values.
+func parseTOMLConfig(data []byte) ([][2]string, error) {
+	var values [][2]string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables aren't supported in config files", n)
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value = strings.TrimSpace(value)
+
+		// Split arrays into their elements.
+		items := []string{value}
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			items = splitTOMLArray(value[1 : len(value)-1])
+		}
+
+		for _, item := range items {
+			v, err := parseTOMLValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			values = append(values, [2]string{key, v})
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// splitTOMLArray splits the contents of a single-line array on commas that
+// aren't inside strings.
+func splitTOMLArray(s string) []string {
+	var items []string
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0 && r == quote && (i == 0 || s[i-1] != '\\'):
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(s[start:]) != "" {
+		items = append(items, s[start:])
+	}
+
+	return items
+}
+
+// parseTOMLValue returns the string form of a single TOML value, dropping any trailing comment.
+func parseTOMLValue(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := strings.LastIndex(s, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		end := strings.LastIndex(s, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1:end], nil
+	default:
+		value, _, _ := strings.Cut(s, "#")
+		return strings.TrimSpace(value), nil
+	}
+}
+
+// writeConfig writes the effective value of every flag in fs to the given
+// file, as TOML if it has a .toml extension and as JSON otherwise.
+func writeConfig(fs *flag.FlagSet, filename string) error {
+	values := make(map[string]interface{})
+	var keys []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if configExcluded[f.Name] {
+			return
+		}
+		keys = append(keys, f.Name)
+
+		switch v := f.Value.(type) {
+		case multiValue:
+			// Write an empty list rather than null, which can't be read back.
+			values[f.Name] = append([]string{}, v.values()...)
+		case flag.Getter:
+			switch value := v.Get().(type) {
+			case bool, int:
+				values[f.Name] = value
+			case time.Duration:
+				values[f.Name] = value.String()
+			default:
+				values[f.Name] = f.Value.String()
+			}
+		default:
+			values[f.Name] = f.Value.String()
+		}
+	})
+
+	if filepath.Ext(filename) != ".toml" {
+		data, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filename, append(data, '\n'), 0644)
+	}
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		switch value := values[key].(type) {
+		case []string:
+			quoted := make([]string, len(value))
+			for i, item := range value {
+				quoted[i] = strconv.Quote(item)
+			}
+			fmt.Fprintf(&buf, "%s = [%s]\n", key, strings.Join(quoted, ", "))
+		case string:
+			fmt.Fprintf(&buf, "%s = %s\n", key, strconv.Quote(value))
+		default:
+			fmt.Fprintf(&buf, "%s = %v\n", key, value)
+		}
+	}
+
+	return os.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// hotReloadable are the flags whose values a config reload applies while the
+// server runs. Changes to other flags take effect once the wrapper restarts.
+var hotReloadable = map[string]bool{
+	"backup-interval":  true,
+	"webhook-url":      true,
+	"webhook-template": true,
+	"log-max-size":     true,
+}
+
+// reloadConfigOnHangup reloads the given config file each time the wrapper
+// receives SIGHUP, calling apply for each changed hot-reloadable flag. Flags
+// in cmdline were set on the command line and are left alone.
+func reloadConfigOnHangup(filename string, cmdline map[string]bool, apply func(name, value string) error) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	for range hangups {
+		log.Printf("received hangup, reloading %s", filename)
+		if err := reloadConfig(flag.CommandLine, filename, cmdline, apply); err != nil {
+			log.Printf("failed to reload %s: %v", filename, err)
+		}
+	}
+}
+
+// reloadConfig reads the given config file and applies the changed values
+// of hot-reloadable flags of fs with apply, which updates the running
+// wrapper, logging each change. Changes to other flags are logged as
+// needing a restart.
+func reloadConfig(fs *flag.FlagSet, filename string, cmdline map[string]bool, apply func(name, value string) error) error {
+	pairs, err := readConfig(filename)
+	if err != nil {
+		return err
+	}
+
+	// Group repeated keys, which set multi-value flags.
+	var names []string
+	values := make(map[string][]string)
+	for _, kv := range pairs {
+		if _, ok := values[kv[0]]; !ok {
+			names = append(names, kv[0])
+		}
+		values[kv[0]] = append(values[kv[0]], kv[1])
+	}
+
+	changed := 0
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil || configExcluded[name] {
+			log.Printf("warning: %s: unknown key %q", filename, name)
+			continue
+		}
+		if cmdline[name] {
+			continue
+		}
+
+		// Secrets left empty in the config come from the environment.
+		value := values[name]
+		if env, ok := secretEnvVars[name]; ok && len(value) == 1 && value[0] == "" {
+			value[0] = os.Getenv(env)
+		}
+		if sameFlagValue(f, value) {
+			continue
+		}
+
+		if !hotReloadable[name] || len(value) != 1 {
+			log.Printf("warning: %s changed but can't be applied while the server runs; restart the wrapper to apply it", name)
+			continue
+		}
+
+		old := f.Value.String()
+		if err := apply(name, value[0]); err != nil {
+			log.Printf("warning: not applying %s: %v", name, err)
+			continue
+		}
+		if err := fs.Set(name, value[0]); err != nil {
+			return err
+		}
+		changed++
+
+		// Don't log secrets.
+		if _, ok := secretEnvVars[name]; ok {
+			log.Printf("changed %s", name)
+		} else {
+			log.Printf("changed %s from %q to %q", name, old, value[0])
+		}
+	}
+
+	if changed == 0 {
+		log.Print("no hot-reloadable settings changed")
+	}
+
+	return nil
+}
+
+// sameFlagValue reports whether the given config values equal the current
+// value of the flag, comparing durations, booleans, and integers by value.
+func sameFlagValue(f *flag.Flag, values []string) bool {
+	if mv, ok := f.Value.(multiValue); ok {
+		return slices.Equal(mv.values(), values)
+	}
+	if len(values) != 1 {
+		return false
+	}
+
+	value := values[0]
+	if getter, ok := f.Value.(flag.Getter); ok {
+		switch current := getter.Get().(type) {
+		case time.Duration:
+			d, err := time.ParseDuration(value)
+			return err == nil && d == current
+		case bool:
+			b, err := strconv.ParseBool(value)
+			return err == nil && b == current
+		case int:
+			n, err := strconv.Atoi(value)
+			return err == nil && n == current
+		}
+	}
+
+	return f.Value.String() == value
+}
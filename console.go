@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+)
+
+// console serializes input to the server from every source, such as the
+// terminal and the control socket, and fans the server's output out to
+// subscribers.
+type console struct {
+	input chan []string
+
+	// inputMu guards attached, which is set while a server reads the input.
+	inputMu  sync.Mutex
+	attached bool
+
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+}
+
+// serverConsole is the console of the wrapped server.
+var serverConsole = newConsole()
+
+// newConsole returns a console with no subscribers.
+func newConsole() *console {
+	return &console{
+		input:       make(chan []string, 64),
+		subscribers: make(map[chan []byte]struct{}),
+	}
+}
+
+var (
+	// errNotRunning is returned for input sent while no server is running.
+	errNotRunning = errors.New("the server isn't running")
+
+	// errInputFull is returned for input sent while the server isn't
+	// reading it fast enough.
+	errInputFull = errors.New("the server's input queue is full")
+)
+
+// trySend queues lines of input for the server to receive together, without
+// waiting. Input is refused rather than held for the next server while none
+// is running, or if the queue is full.
+func (c *console) trySend(lines ...string) error {
+	c.inputMu.Lock()
+	defer c.inputMu.Unlock()
+
+	if !c.attached {
+		return errNotRunning
+	}
+	select {
+	case c.input <- lines:
+		return nil
+	default:
+		return errInputFull
+	}
+}
+
+// forward writes queued input to the given server input until done is
+// closed, discarding the input that the server didn't receive. It is the
+// only writer of the server's input.
+func (c *console) forward(w io.Writer, done <-chan struct{}) {
+	c.inputMu.Lock()
+	c.attached = true
+	c.inputMu.Unlock()
+
+	for {
+		select {
+		case lines := <-c.input:
+			for _, line := range lines {
+				if _, err := io.WriteString(w, line+"\n"); err != nil {
+					log.Printf("failed to send input to server: %v", err)
+				}
+			}
+		case <-done:
+			c.inputMu.Lock()
+			defer c.inputMu.Unlock()
+			c.attached = false
+			for len(c.input) > 0 {
+				<-c.input
+			}
+			return
+		}
+	}
+}
+
+// Write passes a copy of the server's output to every subscriber, dropping
+// it for subscribers that aren't keeping up so the server is never blocked.
+func (c *console) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for sub := range c.subscribers {
+		select {
+		case sub <- append([]byte(nil), b...):
+		default:
+		}
+	}
+
+	return len(b), nil
+}
+
+// subscribe returns a channel that receives the server's output.
+func (c *console) subscribe() chan []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sub := make(chan []byte, 256)
+	c.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe stops the given channel from receiving output and closes it.
+func (c *console) unsubscribe(sub chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.subscribers, sub)
+	close(sub)
+}
+
+// sendInput sends a line of user input to the server, running the commands
+// of the alias instead if it is an @alias. An alias's commands are queued
+// together, so that they all go to the same server or none do.
+func (c *console) sendInput(line string) {
+	commands, err := expandAlias(line)
+	if err == nil {
+		err = c.trySend(commands...)
+	}
+	if err != nil {
+		log.Printf("warning: %v", err)
+	}
+}
+
+// readInput sends each line read from the given reader to the server.
+func (c *console) readInput(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		c.sendInput(scanner.Text())
+	}
+
+	return scanner.Err()
+}
+
+// attach sends lines read from the given connection to the server and
+// streams the server's output back until the connection is closed.
+func (c *console) attach(conn net.Conn) {
+	defer conn.Close()
+
+	sub := c.subscribe()
+	go func() {
+		for b := range sub {
+			if _, err := conn.Write(b); err != nil {
+				return
+			}
+		}
+	}()
+	defer c.unsubscribe(sub)
+
+	if err := c.readInput(conn); err != nil {
+		log.Printf("console connection from %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// listenControlSocket listens on a Unix domain socket at the given path,
+// attaching each connection to the server console.
+func listenControlSocket(path string) (net.Listener, error) {
+	// Remove a socket left behind by a previous run.
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serverConsole.attach(conn)
+		}
+	}()
+
+	return ln, nil
+}
+
+// isTerminal reports whether f is likely an interactive terminal. Char
+// devices other than the null device are assumed to be terminals.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxWebhookCrashReport bounds the crash report attached to the webhook,
+// as webhook receivers limit the size of messages.
+const maxWebhookCrashReport = 16 << 10
+
+// webhookCrashReport attaches the crash report, if one was written, to the
+// crash event posted to the webhook.
+var webhookCrashReport bool
+
+// newestCrashReport returns the newest crash report in the server's
+// crash-reports directory that was written since the given time, or an
+// empty string if there is none.
+func (s *server) newestCrashReport(since time.Time) string {
+	dir := filepath.Join(s.dir, "crash-reports")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	var newest string
+	var newestTime time.Time
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "crash-") || !strings.HasSuffix(name, ".txt") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().Before(since) {
+			continue
+		}
+		if newest == "" || info.ModTime().After(newestTime) {
+			newest, newestTime = filepath.Join(dir, name), info.ModTime()
+		}
+	}
+
+	return newest
+}
+
+// archiveCrashReport copies the given crash report into the crash archive
+// directory, prefixed with the server's name if it has one, so that it
+// survives the crash-reports directory being cleaned. Failures are logged,
+// as they mustn't stop the server from being restarted.
+func (s *server) archiveCrashReport(report string) {
+	if err := prepareDir(s.crashArchiveDir); err != nil {
+		s.logf("warning: failed to archive crash report %s: %v", report, err)
+		return
+	}
+
+	name := filepath.Base(report)
+	if s.name != "" {
+		name = s.name + "-" + name
+	}
+	archived := filepath.Join(s.crashArchiveDir, name)
+	if err := copyFile(report, archived); err != nil {
+		s.logf("warning: failed to archive crash report %s: %v", report, err)
+		return
+	}
+
+	s.logf("archived crash report %s to %s", report, archived)
+}
+
+// readCrashReport returns the start of the given crash report for the
+// webhook, marking it as cut off if it is longer than
+// maxWebhookCrashReport.
+func readCrashReport(report string) string {
+	f, err := os.Open(report)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxWebhookCrashReport+1))
+	if err != nil {
+		return ""
+	}
+	if len(data) <= maxWebhookCrashReport {
+		return string(data)
+	}
+
+	// Drop a rune split by the cut.
+	return strings.ToValidUTF8(string(data[:maxWebhookCrashReport]), "") + "\n[crash report truncated]"
+}
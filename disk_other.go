@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package main
+
+// availableSpace reports that the available space can't be read on this platform.
+func availableSpace(dir string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package main
+
+import "syscall"
+
+// availableSpace returns the number of bytes available to the wrapper in
+// the filesystem holding the given directory, and whether it could be read.
+func availableSpace(dir string) (int64, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, false
+	}
+
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), true
+}
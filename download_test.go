@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadResumesOnlySameFile(t *testing.T) {
+	data, sum := setupSegmented(t, 1)
+	downloadRetries = 2
+
+	const etag = `"v2"`
+	var ranges atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "server.jar", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	garbage := bytes.Repeat([]byte{'x'}, len(data)/2)
+	tests := []struct {
+		name       string
+		partial    []byte
+		source     *partialSource
+		wantRanges int32
+	}{
+		{"same file", data[:len(data)/2], &partialSource{URL: srv.URL, Validator: etag}, 1},
+		{"changed file", garbage, &partialSource{URL: srv.URL, Validator: `"v1"`}, 1},
+		{"other url", garbage, &partialSource{URL: srv.URL + "/old", Validator: etag}, 0},
+		{"unknown source", garbage, nil, 0},
+		{"larger than the file", append(append([]byte{}, data...), garbage...), &partialSource{URL: srv.URL, Validator: etag}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "server.jar")
+			tmp := filename + ".tmp"
+			if err := os.WriteFile(tmp, tt.partial, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.source != nil {
+				if err := writePartialSource(tmp, *tt.source); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Without a checksum, only the file's source shows it can be resumed.
+			ranges.Store(0)
+			if err := downloadFile(context.Background(), filename, srv.URL, "", "", false); err != nil {
+				t.Fatalf("downloadFile: %v", err)
+			}
+			if err := verifySHA1(filename, sum); err != nil {
+				t.Errorf("download: %v", err)
+			}
+			if got := ranges.Load(); got != tt.wantRanges {
+				t.Errorf("server got %d range requests, want %d", got, tt.wantRanges)
+			}
+			if _, err := os.Stat(tmp + partialSourceSuffix); !os.IsNotExist(err) {
+				t.Errorf("the partial download's source was left behind: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// cleanEnvVars are the variables passed on from the wrapper's environment
+// to the server when it is started with a clean environment, which are
+// those that Java and the system need rather than any configuration.
+var cleanEnvVars = []string{"PATH", "HOME", "USER", "LANG", "LC_ALL", "TZ", "TMPDIR"}
+
+// cleanEnvVarsWindows are the variables also passed on on Windows, where
+// the JVM fails to start without some of them.
+var cleanEnvVarsWindows = []string{"SystemRoot", "SystemDrive", "windir", "ComSpec", "PATHEXT", "TEMP", "TMP", "USERPROFILE", "APPDATA", "LOCALAPPDATA", "COMPUTERNAME", "USERNAME"}
+
+// javaEnv returns the environment the server is started with, or nil to
+// inherit the wrapper's. With clean set, only the variables in
+// cleanEnvVars are passed on, with JAVA_HOME and PATH pointing at the
+// runtime of the java executable. The given key=value pairs are added last,
+// overriding either.
+func javaEnv(clean bool, extra [][2]string) []string {
+	if !clean && len(extra) == 0 {
+		return nil
+	}
+
+	var env []string
+	if clean {
+		names := cleanEnvVars
+		if runtime.GOOS == "windows" {
+			names = append(names, cleanEnvVarsWindows...)
+		}
+		for _, name := range names {
+			if value, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+value)
+			}
+		}
+
+		// The server may run java itself, e.g. to restart, so point
+		// JAVA_HOME and PATH at the runtime it was started with rather
+		// than whichever the wrapper's JAVA_HOME names.
+		if bin := filepath.Dir(javaPath); filepath.IsAbs(javaPath) && strings.EqualFold(filepath.Base(bin), "bin") {
+			env = setEnv(env, "JAVA_HOME", filepath.Dir(bin))
+			path := bin
+			if current, ok := os.LookupEnv("PATH"); ok && current != "" {
+				path += string(os.PathListSeparator) + current
+			}
+			env = setEnv(env, "PATH", path)
+		} else if home, ok := os.LookupEnv("JAVA_HOME"); ok {
+			env = setEnv(env, "JAVA_HOME", home)
+		}
+	} else {
+		env = os.Environ()
+	}
+
+	for _, kv := range extra {
+		env = setEnv(env, kv[0], kv[1])
+	}
+
+	return env
+}
+
+// environ returns the environment the server is started with, or nil to
+// inherit the wrapper's. The Bedrock server on Linux loads its libraries
+// from its own directory, which its documentation has it add to
+// LD_LIBRARY_PATH.
+func (s *server) environ() []string {
+	env := javaEnv(cleanEnv, javaEnvVars)
+	if s.executable == "" || runtime.GOOS != "linux" {
+		return env
+	}
+
+	if env == nil {
+		env = os.Environ()
+	}
+	return setEnv(env, "LD_LIBRARY_PATH", filepath.Dir(s.executable))
+}
+
+// setEnv sets the given variable in env, replacing any existing value. The
+// name is matched case insensitively on Windows, as its environment is.
+func setEnv(env []string, name, value string) []string {
+	for i, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if key == name || (runtime.GOOS == "windows" && strings.EqualFold(key, name)) {
+			env[i] = name + "=" + value
+			return env
+		}
+	}
+
+	return append(env, name+"="+value)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fetchFile is a file downloaded before launch with -fetch.
+type fetchFile struct {
+	URL  string
+	SHA1 string
+	Dest string
+}
+
+// fetchFlag collects repeated -fetch url=dest flags in order.
+type fetchFlag []fetchFile
+
+// String returns the collected files as a comma separated list.
+func (f *fetchFlag) String() string {
+	return strings.Join(f.values(), ",")
+}
+
+// Set parses and adds a url=dest pair. The URL may end in a #sha1=<sum>
+// fragment giving the file's checksum. Since URLs may contain '=', the
+// destination is taken from after the last one.
+func (f *fetchFlag) Set(s string) error {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return fmt.Errorf("%q is not in url=dest form", s)
+	}
+
+	u, err := url.Parse(s[:i])
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute URL", s[:i])
+	}
+
+	file := fetchFile{Dest: filepath.FromSlash(s[i+1:])}
+	if u.Fragment != "" {
+		sum, ok := strings.CutPrefix(u.Fragment, "sha1=")
+		if !ok || len(sum) != 40 {
+			return fmt.Errorf("invalid fragment %q in %s: must be #sha1=<40 hex digits>", u.Fragment, s[:i])
+		}
+		file.SHA1 = strings.ToLower(sum)
+		u.Fragment = ""
+	}
+	file.URL = u.String()
+
+	*f = append(*f, file)
+	return nil
+}
+
+// fetchFiles downloads the given files to their destinations, skipping those
+// already present with a matching checksum, or present at all if they have
+// no checksum. Relative destinations are relative to the given directory.
+func fetchFiles(ctx context.Context, files []fetchFile, dir string) error {
+	for _, file := range files {
+		if !filepath.IsAbs(file.Dest) {
+			file.Dest = filepath.Join(dir, file.Dest)
+		}
+		if _, err := os.Stat(file.Dest); err == nil && (file.SHA1 == "" || verifySHA1(file.Dest, file.SHA1) == nil) {
+			log.Printf("%s is up to date, skipping", file.Dest)
+			continue
+		}
+
+		if file.SHA1 == "" {
+			log.Printf("warning: no checksum given for %s, skipping verification", file.Dest)
+		}
+		if err := os.MkdirAll(filepath.Dir(file.Dest), 0755); err != nil {
+			return err
+		}
+
+		log.Printf("downloading %s to %s", file.URL, file.Dest)
+		if err := downloadFile(ctx, file.Dest, file.URL, "sha1", file.SHA1, false); err != nil {
+			return fmt.Errorf("%s: %w", file.Dest, err)
+		}
+	}
+
+	return nil
+}
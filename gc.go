@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// gcPresets are the curated sets of garbage collector options that can be
+// chosen with -gc-preset.
+var gcPresets = map[string][]string{
+	"none": nil,
+	"g1": {
+		"-XX:+UseG1GC",
+		"-XX:MaxGCPauseMillis=200",
+		"-XX:+ParallelRefProcEnabled",
+		"-XX:+DisableExplicitGC",
+	},
+	// Aikar's flags, from https://docs.papermc.io/paper/aikars-flags.
+	"aikar": {
+		"-XX:+UseG1GC",
+		"-XX:+ParallelRefProcEnabled",
+		"-XX:MaxGCPauseMillis=200",
+		"-XX:+UnlockExperimentalVMOptions",
+		"-XX:+DisableExplicitGC",
+		"-XX:+AlwaysPreTouch",
+		"-XX:G1NewSizePercent=30",
+		"-XX:G1MaxNewSizePercent=40",
+		"-XX:G1HeapRegionSize=8M",
+		"-XX:G1ReservePercent=20",
+		"-XX:G1HeapWastePercent=5",
+		"-XX:G1MixedGCCountTarget=4",
+		"-XX:InitiatingHeapOccupancyPercent=15",
+		"-XX:G1MixedGCLiveThresholdPercent=90",
+		"-XX:G1RSetUpdatingPauseTimePercent=5",
+		"-XX:SurvivorRatio=32",
+		"-XX:+PerfDisableSharedMem",
+		"-XX:MaxTenuringThreshold=1",
+		"-Dusing.aikars.flags=https://mcflags.emc.gs",
+		"-Daikars.new.flags=true",
+	},
+}
+
+// aikarLargeHeap is the heap size from which Aikar's flags give the young
+// generation and regions more room, and aikarLargeHeapArgs replace those of
+// the aikar preset for such heaps.
+const aikarLargeHeap = 12 << 30
+
+var aikarLargeHeapArgs = map[string]string{
+	"-XX:G1NewSizePercent":               "40",
+	"-XX:G1MaxNewSizePercent":            "50",
+	"-XX:G1HeapRegionSize":               "16M",
+	"-XX:G1ReservePercent":               "15",
+	"-XX:InitiatingHeapOccupancyPercent": "20",
+}
+
+// validateGCPreset returns an error if the given preset doesn't exist.
+func validateGCPreset(preset string) error {
+	if _, ok := gcPresets[preset]; !ok {
+		return fmt.Errorf("invalid gc preset %q: must be one of %s", preset, strings.Join(gcPresetNames(), ", "))
+	}
+
+	return nil
+}
+
+// gcPresetNames returns the names of the presets in sorted order.
+func gcPresetNames() []string {
+	names := make([]string, 0, len(gcPresets))
+	for name := range gcPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// gcPresetArgs returns the JVM options of the given preset for a server
+// with the given maximum heap, in bytes or zero if unknown.
+func gcPresetArgs(preset string, heap uint64) []string {
+	args := append([]string(nil), gcPresets[preset]...)
+	if preset != "aikar" || heap < aikarLargeHeap {
+		return args
+	}
+
+	for i, arg := range args {
+		name, _, _ := strings.Cut(arg, "=")
+		if value, ok := aikarLargeHeapArgs[name]; ok {
+			args[i] = name + "=" + value
+		}
+	}
+
+	return args
+}
+
+// printGCPresets writes the options each preset expands to, for the usage.
+func printGCPresets(w io.Writer) {
+	for _, name := range gcPresetNames() {
+		if args := gcPresets[name]; len(args) > 0 {
+			printWrapped(w, fmt.Sprintf("  %-6s", name), args)
+		} else {
+			fmt.Fprintf(w, "  %-6s No options.\n", name)
+		}
+	}
+
+	large := make([]string, 0, len(aikarLargeHeapArgs))
+	for _, arg := range gcPresets["aikar"] {
+		name, _, _ := strings.Cut(arg, "=")
+		if value, ok := aikarLargeHeapArgs[name]; ok {
+			large = append(large, name+"="+value)
+		}
+	}
+	fmt.Fprintln(w, "  With a heap of 12G or more, aikar instead uses")
+	printWrapped(w, "        ", large)
+}
+
+// printWrapped writes the given words after the prefix, wrapping lines to
+// fit a terminal and indenting continuation lines.
+func printWrapped(w io.Writer, prefix string, words []string) {
+	line := prefix
+	for _, word := range words {
+		if len(line) > len(prefix) && len(line)+1+len(word) > 79 {
+			fmt.Fprintln(w, line)
+			line = strings.Repeat(" ", len(prefix))
+		}
+		line += " " + word
+	}
+	fmt.Fprintln(w, line)
+}
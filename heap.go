@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// heapTier is the maximum heap a server is given once at least players
+// were online at the same time during its last session.
+type heapTier struct {
+	players int
+	heap    string
+}
+
+// parseHeapTable parses a -dynamic-memory table of comma separated
+// players=heap tiers, e.g. 0=2G,10=4G,25=6G, returning them sorted by
+// player count. Tiers below the given initial heap, if any, are rejected,
+// as the JVM refuses to start with a maximum heap below it.
+func parseHeapTable(spec, xms string) ([]heapTier, error) {
+	var tiers []heapTier
+	seen := make(map[int]bool)
+	for _, field := range strings.Split(spec, ",") {
+		players, heap, ok := strings.Cut(strings.TrimSpace(field), "=")
+		n, err := strconv.Atoi(players)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid dynamic memory tier %q, must be players=heap, e.g. 10=4G", field)
+		}
+		if heap == "" {
+			return nil, fmt.Errorf("invalid dynamic memory tier %q: no heap size", field)
+		}
+		if err := validateMemorySize(heap); err != nil {
+			return nil, fmt.Errorf("invalid dynamic memory tier %q: %w", field, err)
+		}
+		if xms != "" && parseMemorySize(heap) < parseMemorySize(xms) {
+			return nil, fmt.Errorf("invalid dynamic memory tier %q: the heap is below the initial heap of %s", field, xms)
+		}
+		if seen[n] {
+			return nil, fmt.Errorf("invalid dynamic memory table: more than one tier for %d players", n)
+		}
+		seen[n] = true
+
+		tiers = append(tiers, heapTier{players: n, heap: heap})
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].players < tiers[j].players })
+
+	return tiers, nil
+}
+
+// heapFor returns the heap of the largest tier reached by the given peak
+// number of players along with the tier's player count, or an empty string
+// if the peak is below every tier.
+func heapFor(tiers []heapTier, peak int) (string, int) {
+	heap, reached := "", -1
+	for _, tier := range tiers {
+		if peak >= tier.players {
+			heap, reached = tier.heap, tier.players
+		}
+	}
+
+	return heap, reached
+}
+
+// resizeHeap picks the maximum heap for the next start of the server from
+// its heap table by the peak number of players online since the last
+// planned restart. It is only called at planned restarts, as a crashed
+// server is restarted as it was.
+func (s *server) resizeHeap() {
+	peak := s.metrics.takePeakPlayers()
+	if len(s.heapTable) == 0 {
+		return
+	}
+
+	heap, reached := heapFor(s.heapTable, peak)
+	if heap == "" {
+		s.logf("peak of %d players online before the restart is below every dynamic memory tier, keeping heap at %s", peak, s.heapDescription())
+		return
+	}
+	if strings.EqualFold(heap, s.xmx) {
+		s.logf("peak of %d players online before the restart reached the %d player tier, keeping heap at %s", peak, reached, heap)
+		return
+	}
+
+	s.logf("peak of %d players online before the restart reached the %d player tier, changing heap from %s to %s", peak, reached, s.heapDescription(), heap)
+	s.xmx = heap
+}
+
+// heapDescription describes the server's current maximum heap for logs.
+func (s *server) heapDescription() string {
+	if s.xmx == "" {
+		return "the default"
+	}
+
+	return s.xmx
+}
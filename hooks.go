@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// runHook runs the given shell command in the given directory, or the
+// current one if empty, with the given extra environment variables,
+// connecting its output to the wrapper's.
+func runHook(command, dir string, env []string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// hookEnv returns the environment variables describing the server that are
+// passed to its lifecycle hooks.
+func (s *server) hookEnv() []string {
+	dir, err := filepath.Abs(s.dir)
+	if err != nil {
+		dir = s.dir
+	}
+
+	env := []string{"MINECRAFT_SERVER_DIR=" + dir, "MINECRAFT_SERVER_JAR=" + s.jar}
+	if s.version != "" {
+		env = append(env, "MINECRAFT_VERSION="+s.version)
+	}
+	if s.name != "" {
+		env = append(env, "MINECRAFT_SERVER_NAME="+s.name)
+	}
+
+	return env
+}
+
+// runPreStartHook runs the pre-start hook, if any, in the server's
+// directory. The server mustn't start if it fails.
+func (s *server) runPreStartHook() error {
+	if preStart == "" {
+		return nil
+	}
+
+	s.logf("running pre-start hook")
+	if err := runHook(preStart, s.dir, s.hookEnv()); err != nil {
+		return fmt.Errorf("pre-start hook failed, not starting the server: %v", err)
+	}
+
+	return nil
+}
+
+// runPostStopHook runs the post-stop hook, if any, in the server's directory
+// once it has exited with the given code, logging any failure.
+func (s *server) runPostStopHook(code int, crashed bool) {
+	if postStop == "" {
+		return
+	}
+
+	reason := "stop"
+	if crashed {
+		reason = "crash"
+	}
+
+	s.logf("running post-stop hook")
+	env := append(s.hookEnv(), "MINECRAFT_EXIT_CODE="+strconv.Itoa(code), "MINECRAFT_STOP_REASON="+reason)
+	if err := runHook(postStop, s.dir, env); err != nil {
+		s.logf("post-stop hook failed: %v", err)
+	}
+}
+
+// runReadyHook runs the on-ready hook, if any, in the server's directory
+// once it has started in the given number of seconds, logging any failure.
+func (s *server) runReadyHook(duration string) {
+	if onReady == "" {
+		return
+	}
+
+	env := append(s.hookEnv(), "MINECRAFT_STARTUP_DURATION="+duration+"s")
+	if err := runHook(onReady, s.dir, env); err != nil {
+		s.logf("on-ready hook failed: %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// idleCheckInterval is how often the server is checked for online players
+// when it has an idle timeout.
+const idleCheckInterval = 10 * time.Second
+
+// asleepMOTD and wakeMessage are shown to players while the server is
+// stopped for being idle, in the server list and when they try to join.
+const (
+	asleepMOTD  = "Server is asleep, join to start it up"
+	wakeMessage = "The server is starting up, reconnect in a minute"
+)
+
+// connTimeout is how long a client connecting to the idle listener has to
+// send its requests.
+const connTimeout = 10 * time.Second
+
+// watchIdle returns a channel that is closed once no players have been
+// online for the server's idle timeout, or nil if it has none. The server is
+// no longer watched once done is closed.
+func (s *server) watchIdle(done <-chan struct{}) <-chan struct{} {
+	if s.idleTimeout <= 0 {
+		return nil
+	}
+
+	idle := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(min(idleCheckInterval, s.idleTimeout))
+		defer ticker.Stop()
+
+		active := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+
+			if s.metrics.playerCount() > 0 {
+				active = time.Now()
+			} else if time.Since(active) >= s.idleTimeout {
+				s.logf("no players online for %s, stopping server", s.idleTimeout)
+				close(idle)
+				return
+			}
+		}
+	}()
+
+	return idle
+}
+
+// sleepUntilJoin listens on the server's port while it is stopped for being
+// idle, answering status pings with asleepMOTD, until a player tries to join
+// or stop is closed. It reports whether a player tried to join.
+func (s *server) sleepUntilJoin(stop <-chan struct{}) (bool, error) {
+	addr, err := serverAddr(filepath.Join(s.dir, "server.properties"))
+	if err != nil {
+		return false, err
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false, err
+	}
+	defer ln.Close()
+	s.logf("listening on %s to start the server when a player joins", addr)
+
+	joined := make(chan struct{}, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				if answerAsleep(conn) {
+					select {
+					case joined <- struct{}{}:
+					default:
+					}
+				}
+			}()
+		}
+	}()
+
+	select {
+	case <-joined:
+		s.logf("a player tried to join, starting server")
+		return true, nil
+	case <-stop:
+		return false, nil
+	}
+}
+
+// answerAsleep answers a client connecting while the server is asleep,
+// showing asleepMOTD to status pings and disconnecting players trying to
+// join with wakeMessage. It reports whether the client tried to join.
+func answerAsleep(conn net.Conn) bool {
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(connTimeout)); err != nil {
+		return false
+	}
+
+	// The handshake gives the client's protocol version, the address and
+	// port it connected to, and whether it wants the status or to log in.
+	r := bufio.NewReader(conn)
+	handshake, err := readPacket(r, 0x00)
+	if err != nil {
+		return false
+	}
+	protocol, err := readVarInt(handshake)
+	if err != nil {
+		return false
+	}
+	if _, err := readString(handshake); err != nil {
+		return false
+	}
+	var port uint16
+	if err := binary.Read(handshake, binary.BigEndian, &port); err != nil {
+		return false
+	}
+	next, err := readVarInt(handshake)
+	if err != nil {
+		return false
+	}
+
+	if next != 1 {
+		reason, _ := json.Marshal(map[string]string{"text": wakeMessage})
+		var disconnect bytes.Buffer
+		writeString(&disconnect, string(reason))
+		writePacket(conn, 0x00, disconnect.Bytes())
+		return true
+	}
+
+	// Answer the status request with the client's own protocol version so
+	// that the server isn't shown as incompatible.
+	if _, err := readPacket(r, 0x00); err != nil {
+		return false
+	}
+	status, _ := json.Marshal(map[string]interface{}{
+		"version":     map[string]interface{}{"name": "asleep", "protocol": protocol},
+		"players":     map[string]int{"max": 0, "online": 0},
+		"description": map[string]string{"text": asleepMOTD},
+	})
+	var response bytes.Buffer
+	writeString(&response, string(status))
+	if err := writePacket(conn, 0x00, response.Bytes()); err != nil {
+		return false
+	}
+
+	// Echo the ping the client sends to measure latency.
+	if ping, err := readPacket(r, 0x01); err == nil {
+		payload, _ := io.ReadAll(ping)
+		writePacket(conn, 0x01, payload)
+	}
+
+	return false
+}
+
+// readPacket reads a packet with the given ID, returning its payload.
+func readPacket(r *bufio.Reader, id int32) (*bytes.Reader, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if length <= 0 || length > 1<<16 {
+		return nil, errors.New("invalid packet length")
+	}
+
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return nil, err
+	}
+
+	pr := bytes.NewReader(packet)
+	if got, err := readVarInt(pr); err != nil {
+		return nil, err
+	} else if got != id {
+		return nil, errors.New("unexpected packet id")
+	}
+
+	return pr, nil
+}
+
+// writePacket writes a packet with the given ID and payload.
+func writePacket(w io.Writer, id int32, payload []byte) error {
+	var packet bytes.Buffer
+	writeVarInt(&packet, id)
+	packet.Write(payload)
+
+	var frame bytes.Buffer
+	writeVarInt(&frame, int32(packet.Len()))
+	frame.Write(packet.Bytes())
+
+	_, err := w.Write(frame.Bytes())
+	return err
+}
+
+// readString reads a protocol string, which is prefixed by its length.
+func readString(r *bytes.Reader) (string, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if length < 0 || int(length) > r.Len() {
+		return "", errors.New("invalid string length")
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// writeString writes a protocol string to the given buffer.
+func writeString(buf *bytes.Buffer, s string) {
+	writeVarInt(buf, int32(len(s)))
+	buf.WriteString(s)
+}
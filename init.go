@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// prompter asks questions on stderr and reads the answers from stdin.
+type prompter struct {
+	r *bufio.Reader
+}
+
+// ask prints the question with its default, if any, and returns the answer,
+// or the default if the answer is empty. Answers are asked again until
+// validate, if given, accepts them.
+func (p *prompter) ask(question, def string, validate func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: ", question)
+		}
+
+		line, err := p.r.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			return "", errors.New("unexpected end of input")
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = def
+		}
+		if validate == nil {
+			return answer, nil
+		}
+		if err := validate(answer); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// confirm asks a yes or no question, defaulting to no.
+func (p *prompter) confirm(question string) (bool, error) {
+	answer, err := p.ask(question+" [y/N]", "", nil)
+	if err != nil {
+		return false, err
+	}
+
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes", nil
+}
+
+// runInit interactively sets up a new server, asking for the settings that
+// aren't given as flags and writing them to a config file, server.properties,
+// and eula.txt.
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory to set up the server in.")
+	config := fs.String("config", "minecraft-server.json", "Config file to write, as TOML if it has a .toml extension and as JSON otherwise.")
+	force := fs.Bool("force", false, "Overwrite the config file if it exists.")
+	version := fs.String("version", "", "Minecraft version to use, such as 'release', 'snapshot', or a specific version.")
+	xmx := fs.String("xmx", "", "Maximum JVM heap size, e.g. 2G.")
+	port := fs.Int("port", 0, "Port for the server to listen on.")
+	motd := fs.String("motd", "", "Message shown in the server list.")
+	acceptEULAFlag := fs.Bool("accept-eula", false, "Accepts the Minecraft EULA ("+eulaURL+").")
+	fs.DurationVar(&httpTimeout, "http-timeout", httpTimeout, "Timeout for HTTP requests.")
+	addProxyFlags(fs)
+	fs.Parse(args)
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	configFile := filepath.Join(*dir, *config)
+	if _, err := os.Stat(configFile); err == nil && !*force {
+		return fmt.Errorf("%s already exists; rerun with -force to overwrite it", configFile)
+	}
+
+	propertiesFile := filepath.Join(*dir, "server.properties")
+	p, err := loadProperties(propertiesFile)
+	if err != nil {
+		return err
+	}
+
+	prompt := &prompter{r: bufio.NewReader(os.Stdin)}
+
+	if !given["version"] {
+		httpClient = newHTTPClient(httpTimeout)
+		manifestCache = filepath.Join(*dir, "version_manifest.json")
+
+		// Offer the latest versions, but don't require the manifest to ask.
+		var validate func(string) error
+		if manifest, err := getManifest(context.Background()); err != nil {
+			log.Printf("warning: can't list versions: %v", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "The latest release is %s and the latest snapshot is %s.\n", manifest.Latest.Release, manifest.Latest.Snapshot)
+			validate = func(answer string) error {
+				if answer == "release" || answer == "snapshot" {
+					return nil
+				}
+				for _, v := range manifest.Versions {
+					if v.ID == answer {
+						return nil
+					}
+				}
+				return fmt.Errorf("unknown version %q", answer)
+			}
+		}
+		if *version, err = prompt.ask("Minecraft version ('release', 'snapshot', or a version)", "release", validate); err != nil {
+			return err
+		}
+	}
+
+	if !given["xmx"] {
+		def := defaultMaxMemory()
+		if def == "" {
+			def = "2G"
+		}
+		if *xmx, err = prompt.ask("Maximum memory, such as 4G or 2048M", def, validateMemorySize); err != nil {
+			return err
+		}
+	} else if err := validateMemorySize(*xmx); err != nil {
+		return err
+	}
+
+	if !given["port"] {
+		current, ok := p.get("server-port")
+		if !ok || current == "" {
+			current = "25565"
+		}
+		answer, err := prompt.ask("Server port", current, func(answer string) error {
+			n, err := strconv.Atoi(answer)
+			if err != nil {
+				return fmt.Errorf("invalid port %q", answer)
+			}
+			return validatePort(n)
+		})
+		if err != nil {
+			return err
+		}
+		*port, _ = strconv.Atoi(answer)
+	} else if err := validatePort(*port); err != nil {
+		return err
+	}
+
+	if !given["motd"] {
+		current, ok := p.get("motd")
+		if !ok || current == "" {
+			current = "A Minecraft Server"
+		}
+		if *motd, err = prompt.ask("Message of the day", current, nil); err != nil {
+			return err
+		}
+	}
+
+	if !given["accept-eula"] {
+		if *acceptEULAFlag, err = prompt.confirm("Do you accept the Minecraft EULA (" + eulaURL + ")?"); err != nil {
+			return err
+		}
+	}
+
+	// Write only the chosen flags, so that the config doesn't pin defaults.
+	settings := flag.NewFlagSet("config", flag.ContinueOnError)
+	settings.String("version", *version, "")
+	settings.String("xmx", *xmx, "")
+	if err := writeConfig(settings, configFile); err != nil {
+		return err
+	}
+	log.Printf("wrote %s", configFile)
+
+	if err := updateServerProperties(propertiesFile, true, [][2]string{{"motd", *motd}}); err != nil {
+		return err
+	}
+	if err := setServerPort(propertiesFile, *port); err != nil {
+		return err
+	}
+	log.Printf("wrote %s", propertiesFile)
+
+	if *acceptEULAFlag {
+		eulaFile := filepath.Join(*dir, "eula.txt")
+		if err := acceptEULA(eulaFile); err != nil {
+			return err
+		}
+		log.Printf("wrote %s", eulaFile)
+	} else {
+		log.Printf("warning: the EULA isn't accepted, the server won't start until eula.txt has eula=true")
+	}
+
+	log.Printf("start the server from %s with: minecraft-server -config %s", *dir, *config)
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// manifestAttributes are the attributes of a jar's manifest that inspect
+// prints, in order.
+var manifestAttributes = []string{
+	"Implementation-Title",
+	"Implementation-Version",
+	"Implementation-Vendor",
+	"Specification-Version",
+	"Main-Class",
+	"Launcher-Agent-Class",
+	"Created-By",
+}
+
+// readJarManifest returns the main attributes of the META-INF/MANIFEST.MF
+// in the given opened jar.
+func readJarManifest(zr *zip.Reader) (map[string]string, error) {
+	f, err := zr.Open("META-INF/MANIFEST.MF")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseJarManifest(io.LimitReader(f, 1<<20))
+}
+
+// parseJarManifest parses the main section of a jar manifest, which ends at
+// the first blank line. Lines starting with a space continue the value of
+// the previous attribute, as manifests wrap lines at 72 bytes.
+func parseJarManifest(r io.Reader) (map[string]string, error) {
+	attrs := make(map[string]string)
+	var last string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			return attrs, nil
+		case strings.HasPrefix(line, " "):
+			if last != "" {
+				attrs[last] += line[1:]
+			}
+		default:
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid manifest line %q", line)
+			}
+			last = strings.TrimSpace(name)
+			attrs[last] = strings.TrimPrefix(value, " ")
+		}
+	}
+
+	return attrs, scanner.Err()
+}
+
+// jarSignatures returns the signature files in the jar's META-INF, which
+// signed jars have one of for each signer.
+func jarSignatures(zr *zip.Reader) []string {
+	var signatures []string
+	for _, f := range zr.File {
+		dir, name := path.Split(f.Name)
+		if dir != "META-INF/" {
+			continue
+		}
+		switch strings.ToUpper(path.Ext(name)) {
+		case ".SF", ".RSA", ".DSA", ".EC":
+			signatures = append(signatures, f.Name)
+		}
+	}
+
+	return signatures
+}
+
+// runInspect prints the metadata embedded in the server jar, without using
+// the network.
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	filename := fs.String("filename", "server.jar", "Filename of the server.")
+	dir := fs.String("dir", ".", "Directory of the server.")
+	fs.Parse(args)
+
+	jar := filepath.Join(*dir, *filename)
+	if fs.NArg() == 1 {
+		jar = fs.Arg(0)
+	} else if fs.NArg() > 1 {
+		return errors.New("usage: inspect [flags] [file.jar]")
+	}
+
+	zr, err := zip.OpenReader(jar)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	attrs, err := readJarManifest(&zr.Reader)
+	if err != nil {
+		return fmt.Errorf("%s: %w", jar, err)
+	}
+
+	fmt.Printf("%-23s %s\n", "File:", jar)
+	for _, name := range manifestAttributes {
+		if value, ok := attrs[name]; ok {
+			fmt.Printf("%-23s %s\n", name+":", value)
+		}
+	}
+	if id := embeddedVersionID(jar); id != "" {
+		fmt.Printf("%-23s %s\n", "version.json ID:", id)
+	}
+	if signatures := jarSignatures(&zr.Reader); len(signatures) > 0 {
+		fmt.Printf("%-23s %s\n", "Signed:", strings.Join(signatures, ", "))
+	} else {
+		fmt.Printf("%-23s no\n", "Signed:")
+	}
+
+	return nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// installedVersionFile is the sidecar in the server directory recording the
+// version of the installed server.
+const installedVersionFile = "version.json"
+
+// pinnedVersionFile is the file in the server directory giving the version
+// to use when none is given explicitly.
+const pinnedVersionFile = ".mcversion"
+
+// versionHistoryLimit is the number of previously installed versions kept
+// in the sidecar to roll back to.
+const versionHistoryLimit = 10
+
+// installedVersion is the record of an installed server version. History
+// holds the versions installed before it, most recent first, and RolledBack
+// is set once the server is rolled back to it, so that it is kept until a
+// version is given explicitly.
+type installedVersion struct {
+	ID           string             `json:"id"`
+	Distribution string             `json:"distribution"`
+	SHA1         string             `json:"sha1"`
+	Installed    time.Time          `json:"installed"`
+	RolledBack   bool               `json:"rolled_back,omitempty"`
+	History      []installedVersion `json:"history,omitempty"`
+}
+
+// recordVersion records the given version as installed at the given filename
+// in the sidecar next to it, moving the version it replaces into the
+// history. Failures are logged, as the record is only informational.
+func recordVersion(version resolvedVersion, filename string) {
+	sum, err := fileChecksum(filename, "sha1")
+	if err == nil {
+		installed := installedVersion{
+			ID:           version.ID,
+			Distribution: version.Distribution,
+			SHA1:         sum,
+			Installed:    time.Now().UTC(),
+		}
+		if previous, err := readInstalledVersion(filename); err == nil {
+			if previous.SHA1 == sum {
+				installed.RolledBack = previous.RolledBack
+				installed.History = previous.History
+			} else {
+				installed.History = pushVersionHistory(previous)
+			}
+		}
+		err = writeInstalledVersion(filename, installed)
+	}
+	if err != nil {
+		log.Printf("warning: failed to record installed version: %v", err)
+	}
+}
+
+// pushVersionHistory returns the history of the given version with the
+// version itself added to the front, keeping at most versionHistoryLimit.
+func pushVersionHistory(installed installedVersion) []installedVersion {
+	history := installed.History
+	installed.History, installed.RolledBack = nil, false
+
+	history = append([]installedVersion{installed}, history...)
+	if len(history) > versionHistoryLimit {
+		history = history[:versionHistoryLimit]
+	}
+
+	return history
+}
+
+// writeInstalledVersion writes the sidecar recording the version of the
+// server at the given filename.
+func writeInstalledVersion(filename string, installed installedVersion) error {
+	return writeJSONFile(filepath.Join(filepath.Dir(filename), installedVersionFile), installed)
+}
+
+// rolledBackVersion returns the version recorded for the server at the given
+// filename if it was rolled back to and hasn't been changed since.
+func rolledBackVersion(filename string) (installedVersion, bool) {
+	installed, err := readInstalledVersion(filename)
+	if err != nil || !installed.RolledBack {
+		return installedVersion{}, false
+	}
+
+	return installed, true
+}
+
+// readPinnedVersion returns the version ID in the pinnedVersionFile of the
+// given directory, or an empty string if there is none. Blank lines and
+// comment lines starting with # are ignored.
+func readPinnedVersion(dir string) (string, error) {
+	filename := filepath.Join(dir, pinnedVersionFile)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	var id string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if id != "" {
+			return "", fmt.Errorf("%s: more than one version given", filename)
+		}
+		id = line
+	}
+	if id == "" {
+		return "", fmt.Errorf("%s: no version given", filename)
+	}
+
+	return id, nil
+}
+
+// readInstalledVersion reads the sidecar recording the version of the
+// server at the given filename.
+func readInstalledVersion(filename string) (installedVersion, error) {
+	var installed installedVersion
+	sidecar := filepath.Join(filepath.Dir(filename), installedVersionFile)
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		return installed, err
+	}
+
+	if err := json.Unmarshal(data, &installed); err != nil {
+		return installed, fmt.Errorf("%s: %w", sidecar, err)
+	}
+
+	return installed, nil
+}
+
+// showVersion prints the version of the server at the given filename from
+// its sidecar, inferring it from the version manifest if there is none.
+func showVersion(ctx context.Context, filename string) error {
+	installed, err := readInstalledVersion(filename)
+	if err == nil {
+		// The record is stale if the jar was replaced by hand.
+		if sum, err := fileChecksum(filename, "sha1"); err == nil && sum != installed.SHA1 {
+			log.Printf("warning: %s doesn't match the recorded version, it may have been replaced", filename)
+		}
+
+		fmt.Printf("%s (%s, sha1 %s, installed %s)\n", installed.ID, installed.Distribution, installed.SHA1, installed.Installed.Format(time.RFC3339))
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	version, err := inferVersion(ctx, filename)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (vanilla, inferred from the version manifest)\n", version.ID)
+	return nil
+}
+
+// inferVersion finds the vanilla version whose server matches the jar at
+// the given filename, trying the version the jar reports first and then
+// every version in the manifest, newest first.
+func inferVersion(ctx context.Context, filename string) (resolvedVersion, error) {
+	sum, err := fileChecksum(filename, "sha1")
+	if err != nil {
+		return resolvedVersion{}, err
+	}
+
+	manifest, err := getManifest(ctx)
+	if err != nil {
+		return resolvedVersion{}, err
+	}
+
+	ids := make([]string, 0, len(manifest.Versions)+1)
+	if id := embeddedVersionID(filename); id != "" {
+		ids = append(ids, id)
+	}
+	for _, v := range manifest.Versions {
+		ids = append(ids, v.ID)
+	}
+
+	log.Printf("no %s found, looking up %s in the version manifest", installedVersionFile, filename)
+	for _, id := range ids {
+		version, err := vanillaResolver{}.Resolve(ctx, id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return resolvedVersion{}, err
+			}
+			continue
+		}
+		if version.Checksum == sum {
+			return version, nil
+		}
+	}
+
+	return resolvedVersion{}, errors.New("unknown version: the server doesn't match any vanilla version")
+}
+
+// embeddedVersionID returns the version ID recorded in the version.json
+// that server jars since 1.14 contain, or an empty string if there is none.
+func embeddedVersionID(filename string) string {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return ""
+	}
+	defer zr.Close()
+
+	f, err := zr.Open("version.json")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var info struct {
+		ID string
+	}
+	data, err := io.ReadAll(io.LimitReader(f, 1<<20))
+	if err != nil || json.Unmarshal(data, &info) != nil {
+		return ""
+	}
+
+	return info.ID
+}
+
+// runVerify checks the installed server against the checksum expected for
+// its version, which is taken from the version.json sidecar without using
+// the network unless a version is given.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	filename := fs.String("filename", "server.jar", "Filename of the server.")
+	dir := fs.String("dir", ".", "Directory of the server.")
+	version := fs.String("version", "", "Version to verify against, looked up online. Defaults to the version recorded in version.json.")
+	distribution := fs.String("distribution", "vanilla", "Server distribution of -version. Must be 'vanilla' (default), 'paper', or 'fabric'.")
+	fs.DurationVar(&httpTimeout, "http-timeout", httpTimeout, "Timeout for HTTP requests.")
+	addProxyFlags(fs)
+	fs.Parse(args)
+
+	jar := filepath.Join(*dir, *filename)
+	var id, expected string
+	if *version != "" {
+		httpClient = newHTTPClient(httpTimeout)
+		manifestCache = filepath.Join(*dir, "version_manifest.json")
+
+		resolver, err := newResolver(*distribution)
+		if err != nil {
+			return err
+		}
+		resolved, err := resolver.Resolve(context.Background(), *version)
+		if err != nil {
+			return err
+		}
+		if resolved.Checksum == "" {
+			return fmt.Errorf("no checksum is published for %s %s", *distribution, resolved.ID)
+		}
+		id, expected = resolved.ID, resolved.Checksum
+	} else {
+		installed, err := readInstalledVersion(jar)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no %s records the version of %s; rerun with -version", installedVersionFile, jar)
+		} else if err != nil {
+			return err
+		}
+		id, expected = installed.ID, installed.SHA1
+	}
+
+	algo := detectChecksumAlgo(expected)
+	actual, err := fileChecksum(jar, algo)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("expected %s: %s\n", algo, expected)
+	fmt.Printf("actual   %s: %s\n", algo, actual)
+	if !strings.EqualFold(actual, expected) {
+		fmt.Printf("FAIL: %s doesn't match %s\n", jar, id)
+		return fmt.Errorf("%s: %w", jar, errChecksumMismatch)
+	}
+
+	fmt.Printf("PASS: %s matches %s\n", jar, id)
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// integrityLoop verifies the server jar against its recorded checksum every
+// integrityInterval until done is closed, so that a jar damaged on disk is
+// noticed before the next restart fails to start it. The jar is only read.
+// A failure is logged and posted to the webhook once, until the jar
+// verifies again.
+func (s *server) integrityLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(s.integrityInterval)
+	defer ticker.Stop()
+
+	failing, unrecorded := false, false
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		checksum, err := os.ReadFile(s.jar + ".sha1")
+		if os.IsNotExist(err) {
+			if !unrecorded {
+				s.logf("warning: no recorded checksum for %s, skipping integrity checks until one is", s.jar)
+				unrecorded = true
+			}
+			continue
+		} else if err != nil {
+			s.logf("warning: integrity check of %s failed: %v", s.jar, err)
+			continue
+		}
+		unrecorded = false
+
+		// The jar may have just been replaced by an update, with the record
+		// written after it, so check again before reporting a mismatch. The
+		// record's algorithm is detected, as it needn't match -checksum-algo.
+		err = verifyChecksum(s.jar, "", strings.TrimSpace(string(checksum)))
+		if err != nil {
+			if again, rerr := os.ReadFile(s.jar + ".sha1"); rerr == nil && string(again) != string(checksum) {
+				continue
+			}
+		}
+
+		switch {
+		case err != nil && !failing:
+			s.logf("warning: integrity check of %s failed, it may be damaged on disk and fail to start at the next restart: %v", s.jar, err)
+			go s.notify("integrity-failure", nil)
+			failing = true
+		case err == nil && failing:
+			s.logf("%s passes its integrity check again", s.jar)
+			failing = false
+		}
+	}
+}
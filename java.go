@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// memorySizePattern matches JVM memory sizes such as 2G or 1024M.
+var memorySizePattern = regexp.MustCompile(`^[1-9][0-9]*[kKmMgG]?$`)
+
+// validateMemorySize returns an error if the given size isn't a valid JVM memory size.
+func validateMemorySize(size string) error {
+	if size != "" && !memorySizePattern.MatchString(size) {
+		return fmt.Errorf("invalid memory size %q, must be a number with an optional K, M, or G suffix", size)
+	}
+
+	return nil
+}
+
+// parseMemorySize returns the number of bytes in a valid JVM memory size,
+// or 0 for an empty size.
+func parseMemorySize(size string) uint64 {
+	if size == "" {
+		return 0
+	}
+
+	shift := 0
+	switch size[len(size)-1] {
+	case 'k', 'K':
+		shift = 10
+	case 'm', 'M':
+		shift = 20
+	case 'g', 'G':
+		shift = 30
+	}
+	if shift != 0 {
+		size = size[:len(size)-1]
+	}
+
+	n, _ := strconv.ParseUint(size, 10, 64)
+	return n << shift
+}
+
+// defaultMaxMemory returns a maximum heap size of half the system memory, or
+// an empty string if the system memory can't be detected.
+func defaultMaxMemory() string {
+	total := systemMemory()
+	if total == 0 {
+		return ""
+	}
+
+	return strconv.FormatUint(total/2/1024/1024, 10) + "M"
+}
+
+// systemMemory returns the total system memory in bytes, or 0 if unknown.
+func systemMemory() uint64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	// Find the line of the form "MemTotal: 16318480 kB".
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+
+	return 0
+}
+
+// hasJVMOption reports whether any of the given arguments starts with the given option prefix.
+func hasJVMOption(args []string, prefix string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// javaInstallHints are instructions for installing Java on each operating system.
+var javaInstallHints = map[string]string{
+	"linux":   "install a JRE with your package manager, e.g. `sudo apt install openjdk-21-jre-headless` or `sudo dnf install java-21-openjdk-headless`",
+	"darwin":  "install a JRE with `brew install openjdk@21`, or download one from https://adoptium.net",
+	"windows": "download and run the Temurin JRE installer from https://adoptium.net, enabling the option to add it to PATH",
+}
+
+// resolveJava returns the path of the given java executable, explaining how
+// to install Java if it can't be found.
+func resolveJava(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		hint, ok := javaInstallHints[runtime.GOOS]
+		if !ok {
+			hint = "install a JRE from https://adoptium.net"
+		}
+		return "", fmt.Errorf("java executable %q not found: %w\n\n"+
+			"Minecraft servers need Java to run; recent versions need Java 21 or newer. To fix this, either:\n"+
+			"  - %s,\n"+
+			"  - point -java at an existing java executable, or\n"+
+			"  - rerun with -manage-java to download a matching Java runtime automatically.\n"+
+			"See https://minecraft.wiki/w/Tutorials/Setting_up_a_server for help.", name, err, hint)
+	}
+
+	return path, nil
+}
+
+// javaVersionPattern matches the version in the output of java -version,
+// e.g. `openjdk version "17.0.2"` or `java version "1.8.0_292"`.
+var javaVersionPattern = regexp.MustCompile(`version "(?:1\.)?(\d+)`)
+
+// javaMajorVersion returns the major version of the given java executable.
+func javaMajorVersion(path string) (int, error) {
+	out, err := exec.Command(path, "-version").CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("failed to run %s -version: %w", path, err)
+	}
+
+	m := javaVersionPattern.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("failed to parse java version from %q", strings.TrimSpace(string(out)))
+	}
+
+	return strconv.Atoi(string(m[1]))
+}
+
+// checkJavaVersion returns an error if the given java executable is older
+// than the required major version, which defaults to Java 8 if unknown.
+func checkJavaVersion(path string, required int) error {
+	if required == 0 {
+		required = 8
+	}
+
+	major, err := javaMajorVersion(path)
+	if err != nil {
+		return err
+	}
+
+	if major < required {
+		return fmt.Errorf("this Minecraft version requires Java %d or newer but %s is Java %d; install a newer Java, point -java at it, or use -skip-java-check", required, path, major)
+	}
+
+	return nil
+}
+
+// command returns the full command line the server is launched with, which
+// runs the native executable of a Bedrock server with the server's
+// arguments rather than java.
+func (s *server) command() []string {
+	if s.executable != "" {
+		return append([]string{s.executable}, s.args...)
+	}
+
+	return append([]string{javaPath}, s.javaArgs()...)
+}
+
+// javaArgs returns the arguments used to launch the server. The options of
+// the GC preset come first, then those from MINECRAFT_JAVA_OPTS, so that the
+// server's arguments can override both, followed by the memory flags and
+// -jar.
+func (s *server) javaArgs() []string {
+	xmx := s.xmx
+	javaArgs := strings.Fields(os.Getenv("MINECRAFT_JAVA_OPTS"))
+	javaArgs = append(javaArgs, s.args...)
+	if xmx == "" && !hasJVMOption(javaArgs, "-Xmx") {
+		xmx = defaultMaxMemory()
+	}
+
+	javaArgs = append(gcPresetArgs(s.gcPreset, parseMemorySize(xmx)), javaArgs...)
+	javaArgs = append(javaArgs, "-server")
+	if s.xms != "" {
+		javaArgs = append(javaArgs, "-Xms"+s.xms)
+	}
+	if xmx != "" {
+		javaArgs = append(javaArgs, "-Xmx"+xmx)
+	}
+
+	return append(javaArgs, "-jar", s.jar, "nogui")
+}
+
+// shellSafePattern matches arguments that need no quoting in a POSIX shell.
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellJoin joins the given arguments into a command line that can be
+// pasted into a POSIX shell, single-quoting those that need it.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if shellSafePattern.MatchString(arg) {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
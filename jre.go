@@ -0,0 +1,392 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// adoptiumAPI is the base URL of the Adoptium API that managed Java
+// runtimes are downloaded from.
+var adoptiumAPI = "https://api.adoptium.net/v3"
+
+// defaultManagedJava is the Java version installed when the server's
+// requirement isn't known, such as for distributions that don't publish it.
+const defaultManagedJava = 21
+
+// defaultJavaCacheDir returns the default directory for managed Java
+// runtimes, in the user's cache directory if there is one.
+func defaultJavaCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "java"
+	}
+
+	return filepath.Join(dir, "minecraft-server", "java")
+}
+
+// adoptiumAsset is a release binary listed by the Adoptium API.
+type adoptiumAsset struct {
+	ReleaseName string `json:"release_name"`
+	Binary      struct {
+		Package struct {
+			Name     string
+			Link     string
+			Checksum string
+		}
+	}
+}
+
+// installJava returns the java executable of a Java runtime of the given
+// major version in the cache directory, downloading it from Adoptium if it
+// isn't installed yet. If offline, only an installed runtime is used.
+func installJava(ctx context.Context, cacheDir string, major int, offline bool) (string, error) {
+	if offline {
+		return cachedJava(cacheDir, major)
+	}
+	if major == 0 {
+		log.Printf("warning: the server's Java requirement isn't known, using Java %d", defaultManagedJava)
+		major = defaultManagedJava
+	}
+
+	dir := filepath.Join(cacheDir, "jre-"+strconv.Itoa(major))
+	if java, err := findJava(dir); err == nil {
+		return java, nil
+	}
+
+	asset, err := latestJRE(ctx, major)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+
+	// Verify the archive against Adoptium's checksum before extracting it.
+	pkg := asset.Binary.Package
+	archive := filepath.Join(cacheDir, pkg.Name)
+	log.Printf("downloading Java runtime %s", asset.ReleaseName)
+	if err := downloadFile(ctx, archive, pkg.Link, "sha256", pkg.Checksum, strings.HasSuffix(pkg.Name, ".zip")); err != nil {
+		return "", err
+	}
+	defer os.Remove(archive)
+
+	// Extract next to the final directory so a failed extraction is never used.
+	tmp, err := os.MkdirTemp(cacheDir, ".jre-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	if strings.HasSuffix(pkg.Name, ".zip") {
+		err = extractZip(archive, tmp)
+	} else {
+		err = extractTarGz(archive, tmp)
+	}
+	if err != nil {
+		return "", fmt.Errorf("extracting %s: %w", pkg.Name, err)
+	}
+
+	os.RemoveAll(dir)
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", err
+	}
+
+	return findJava(dir)
+}
+
+// cachedJava returns the java executable of the installed runtime of the
+// given major version, or of the newest installed runtime if the server's
+// requirement isn't known, without downloading one.
+func cachedJava(cacheDir string, major int) (string, error) {
+	if major != 0 {
+		java, err := findJava(filepath.Join(cacheDir, "jre-"+strconv.Itoa(major)))
+		if err != nil {
+			return "", fmt.Errorf("Java %d isn't installed in %s, and -offline prevents downloading it; run once without -offline to install it", major, cacheDir)
+		}
+		return java, nil
+	}
+
+	entries, _ := os.ReadDir(cacheDir)
+	newest, newestDir := 0, ""
+	for _, entry := range entries {
+		version, ok := strings.CutPrefix(entry.Name(), "jre-")
+		if n, err := strconv.Atoi(version); ok && err == nil && entry.IsDir() && n > newest {
+			newest, newestDir = n, filepath.Join(cacheDir, entry.Name())
+		}
+	}
+	if newestDir == "" {
+		return "", fmt.Errorf("no Java runtime is installed in %s, and -offline prevents downloading one; run once without -offline to install it", cacheDir)
+	}
+	log.Printf("warning: the server's Java requirement isn't known offline, using the installed Java %d", newest)
+
+	return findJava(newestDir)
+}
+
+// latestJRE returns the newest Adoptium JRE of the given major version for
+// this platform.
+func latestJRE(ctx context.Context, major int) (adoptiumAsset, error) {
+	osName, arch, err := adoptiumPlatform()
+	if err != nil {
+		return adoptiumAsset{}, err
+	}
+
+	query := url.Values{
+		"architecture": {arch},
+		"image_type":   {"jre"},
+		"os":           {osName},
+		"vendor":       {"eclipse"},
+	}
+
+	var assets []adoptiumAsset
+	if err := getJSON(ctx, fmt.Sprintf("%s/assets/latest/%d/hotspot?%s", adoptiumAPI, major, query.Encode()), &assets); err != nil {
+		return adoptiumAsset{}, err
+	}
+	if len(assets) == 0 {
+		return adoptiumAsset{}, fmt.Errorf("no Java %d runtime is available for %s/%s", major, osName, arch)
+	}
+	if assets[0].Binary.Package.Checksum == "" {
+		return adoptiumAsset{}, fmt.Errorf("no checksum is published for %s", assets[0].ReleaseName)
+	}
+
+	return assets[0], nil
+}
+
+// adoptiumPlatform returns the Adoptium names of this operating system and architecture.
+func adoptiumPlatform() (string, string, error) {
+	osNames := map[string]string{"linux": "linux", "darwin": "mac", "windows": "windows"}
+	arches := map[string]string{"amd64": "x64", "arm64": "aarch64", "386": "x32", "arm": "arm"}
+
+	osName, ok := osNames[runtime.GOOS]
+	if !ok {
+		return "", "", fmt.Errorf("managed Java isn't available for %s", runtime.GOOS)
+	}
+	arch, ok := arches[runtime.GOARCH]
+	if !ok {
+		return "", "", fmt.Errorf("managed Java isn't available for %s", runtime.GOARCH)
+	}
+
+	return osName, arch, nil
+}
+
+// findJava returns the java executable of the runtime extracted into dir,
+// which is usually nested in a directory named after the release.
+func findJava(dir string) (string, error) {
+	name := "java"
+	if runtime.GOOS == "windows" {
+		name = "java.exe"
+	}
+
+	for _, pattern := range []string{"bin", "*/bin", "*/Contents/Home/bin"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern, name))
+		if err != nil {
+			return "", err
+		}
+		if len(matches) > 0 {
+			return matches[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("no %s found in %s", name, dir)
+}
+
+// extractPath returns the path an archive entry is extracted to in dir,
+// rejecting entries that would escape it.
+func extractPath(dir, name string) (string, error) {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if !withinDir(dir, path) {
+		return "", fmt.Errorf("archive entry %q is outside the archive", name)
+	}
+
+	return path, nil
+}
+
+// withinDir reports whether path is dir or under it.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkLink returns an error if the symlink an archive entry creates at
+// path in dir would point outside dir, through which later entries could
+// be written anywhere. The link is resolved through any links that exist
+// already, as the operating system would.
+func checkLink(dir, path, name, target string) error {
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("archive entry %q links outside the archive", name)
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	realDir, err := realPath(absDir, 0)
+	if err != nil {
+		return err
+	}
+	parent, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	resolved, err := realPath(parent+string(filepath.Separator)+filepath.FromSlash(target), 0)
+	if err != nil || !withinDir(realDir, resolved) {
+		return fmt.Errorf("archive entry %q links outside the archive", name)
+	}
+
+	return nil
+}
+
+// realPath resolves the given absolute path as the operating system would,
+// applying each ".." after following the links before it, which
+// filepath.EvalSymlinks doesn't as it cleans the path first. Components
+// that don't exist are taken as they are. links counts the links followed
+// so far, to stop at loops.
+func realPath(path string, links int) (string, error) {
+	vol := filepath.VolumeName(path)
+	resolved := vol + string(filepath.Separator)
+	parts := strings.FieldsFunc(path[len(vol):], func(r rune) bool { return r < 0x80 && os.IsPathSeparator(uint8(r)) })
+	for _, part := range parts {
+		switch part {
+		case ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(next)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many links in %s", path)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = resolved + string(filepath.Separator) + target
+		}
+		if resolved, err = realPath(target, links); err != nil {
+			return "", err
+		}
+	}
+
+	return resolved, nil
+}
+
+// extractTarGz extracts the given gzipped tar archive into dir.
+func extractTarGz(filename, dir string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		path, err := extractPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			err = writeExtracted(path, tr, header.FileInfo().Mode())
+		case tar.TypeSymlink:
+			if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := checkLink(dir, path, header.Name, header.Linkname); err != nil {
+				return err
+			}
+			err = os.Symlink(header.Linkname, path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractZip extracts the given zip archive into dir.
+func extractZip(filename, dir string) error {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		path, err := extractPath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeExtracted(path, r, f.Mode())
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeExtracted writes an extracted file with the given mode, creating its directory.
+func writeExtracted(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
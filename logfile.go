@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is a log file that is rotated once it reaches a maximum size,
+// renaming older logs to filename.1, filename.2, and so on.
+type rotatingFile struct {
+	mu       sync.Mutex
+	filename string
+	maxSize  int64
+	keep     int
+	file     *os.File
+	size     int64
+}
+
+// openRotatingFile opens the given log file for appending. A maxSize of zero
+// or less disables rotation, and keep is the number of rotated logs kept.
+func openRotatingFile(filename string, maxSize int64, keep int) (*rotatingFile, error) {
+	f := &rotatingFile{filename: filename, maxSize: maxSize, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// setMaxSize changes the size at which the log file is rotated.
+func (f *rotatingFile) setMaxSize(maxSize int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.maxSize = maxSize
+}
+
+// open opens the log file, appending to any existing contents.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write writes the given bytes to the log file, rotating it first if they
+// would take it over the maximum size.
+func (f *rotatingFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the existing logs up by one, dropping the oldest, and opens a new log file.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", f.filename, f.keep))
+	for i := f.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.filename, i), fmt.Sprintf("%s.%d", f.filename, i+1))
+	}
+
+	if f.keep > 0 {
+		if err := os.Rename(f.filename, f.filename+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.filename); err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+// Close flushes and closes the log file.
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+
+	return f.file.Close()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"time"
+)
+
+// followInterval is how often a followed log file is checked for new lines.
+const followInterval = 250 * time.Millisecond
+
+// runLogs prints the last lines of the server's log file, optionally
+// following it as new lines are written.
+func runLogs(args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	logFile := fs.String("log-file", "", "Log file of the server.")
+	config := fs.String("config", "", "Config file of the server to read the log-file from, if -log-file isn't given.")
+	n := fs.Int("tail", 10, "Number of lines to print from the end of the log.")
+	follow := fs.Bool("follow", false, "Keeps printing new lines as they are written, following the log across rotations.")
+	fs.Parse(args)
+
+	filename := *logFile
+	if filename == "" && *config != "" {
+		values, err := readConfig(*config)
+		if err != nil {
+			return err
+		}
+		for _, kv := range values {
+			if kv[0] == "log-file" {
+				filename = kv[1]
+			}
+		}
+	}
+	if filename == "" {
+		return errors.New("usage: logs -log-file <file> | -config <file> [-tail n] [-follow]")
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	start, err := lastLines(file, *n)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(os.Stdout, file); err != nil {
+		return err
+	}
+
+	for *follow {
+		time.Sleep(followInterval)
+		if _, err := io.Copy(os.Stdout, file); err != nil {
+			return err
+		}
+
+		current, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		latest, err := os.Stat(filename)
+		switch {
+		case err != nil:
+			// The file is between being rotated and recreated.
+		case !os.SameFile(current, latest):
+			// The file was rotated; the rest of the old one has been printed.
+			reopened, err := os.Open(filename)
+			if err != nil {
+				continue
+			}
+			file.Close()
+			file = reopened
+		default:
+			// Start over if the file was truncated.
+			offset, err := file.Seek(0, io.SeekCurrent)
+			if err == nil && latest.Size() < offset {
+				file.Seek(0, io.SeekStart)
+			}
+		}
+	}
+
+	return nil
+}
+
+// lastLines returns the offset in the file at which its last n lines start.
+func lastLines(file *os.File, n int) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if n <= 0 {
+		return size, nil
+	}
+
+	// Read backwards in chunks, counting the newlines that end each line
+	// other than the last.
+	buf := make([]byte, 4096)
+	count := 0
+	for pos := size; pos > 0; {
+		chunk := min(int64(len(buf)), pos)
+		pos -= chunk
+		if _, err := file.ReadAt(buf[:chunk], pos); err != nil {
+			return 0, err
+		}
+
+		for i := chunk - 1; i >= 0; i-- {
+			if buf[i] != '\n' || pos+i == size-1 {
+				continue
+			}
+			if count++; count == n {
+				return pos + i + 1, nil
+			}
+		}
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maintenanceFile is the file in the server directory stashing the
+// properties that maintenance mode changed, which exists only while the
+// server is in maintenance mode.
+const maintenanceFile = ".maintenance.json"
+
+// defaultMaintenanceMOTD is the MOTD shown during maintenance unless
+// another is given.
+const defaultMaintenanceMOTD = "Maintenance in progress, back soon"
+
+// maintenanceStash records the values that properties had before
+// maintenance mode changed them, with nil for those that weren't set.
+type maintenanceStash struct {
+	Since      time.Time          `json:"since"`
+	Properties map[string]*string `json:"properties"`
+}
+
+// runMaintenance switches maintenance mode on or off. Turning it on replaces
+// the MOTD in server.properties, stashing the original to be restored when
+// it is turned off. As vanilla servers can't change their MOTD while
+// running, the change is applied live only by a plugin command given with
+// -motd-command.
+func runMaintenance(args []string) error {
+	fs := flag.NewFlagSet("maintenance", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory of the server.")
+	zeroMaxPlayers := fs.Bool("zero-max-players", false, "Also sets max-players to 0 while in maintenance, so that players can't join.")
+	motdCommand := fs.String("motd-command", "", "Command setting the MOTD of the running server over RCON, with %s replaced by the MOTD, for servers with a plugin that provides one.")
+	fs.StringVar(&rconHost, "rcon-host", "localhost", "Host of the server's RCON interface.")
+	fs.StringVar(&rconPort, "rcon-port", "25575", "Port of the server's RCON interface.")
+	fs.StringVar(&rconPassword, "rcon-password", "", "RCON password of the server, used to announce the change if it's running. Defaults to MINECRAFT_RCON_PASSWORD.")
+	fs.Parse(args)
+	if err := applySecretEnv(fs); err != nil {
+		return err
+	}
+
+	usage := errors.New("usage: maintenance [flags] on [motd] | off | status")
+	if fs.NArg() == 0 {
+		return usage
+	}
+	if *motdCommand != "" && strings.Count(*motdCommand, "%s") != 1 {
+		return errors.New("-motd-command must contain a single %s for the MOTD")
+	}
+
+	propertiesFile := filepath.Join(*dir, "server.properties")
+	stashFile := filepath.Join(*dir, maintenanceFile)
+	stash, err := readMaintenanceStash(stashFile)
+	if err != nil {
+		return err
+	}
+
+	switch action := fs.Arg(0); {
+	case action == "status" && fs.NArg() == 1:
+		if stash == nil {
+			fmt.Println("Maintenance mode is off.")
+			return nil
+		}
+		fmt.Printf("Maintenance mode is on since %s.\n", stash.Since.Local().Format(time.RFC1123))
+		p, err := loadProperties(propertiesFile)
+		if err != nil {
+			return err
+		}
+		if motd, ok := p.get("motd"); ok {
+			fmt.Printf("MOTD: %s\n", motd)
+		}
+		return nil
+
+	case action == "on" && fs.NArg() <= 2:
+		motd := defaultMaintenanceMOTD
+		if fs.NArg() == 2 {
+			motd = fs.Arg(1)
+		}
+		if strings.ContainsAny(motd, "\r\n") {
+			return errors.New("the MOTD must be a single line")
+		}
+
+		changes := [][2]string{{"motd", motd}}
+		if *zeroMaxPlayers {
+			changes = append(changes, [2]string{"max-players", "0"})
+		}
+		if err := enterMaintenance(propertiesFile, stashFile, stash, changes); err != nil {
+			return err
+		}
+		log.Printf("maintenance mode is on, with the MOTD %q", motd)
+
+		reloadMOTD(*motdCommand, motd)
+		reloadOverRCON("say " + motd)
+		return nil
+
+	case action == "off" && fs.NArg() == 1:
+		if stash == nil {
+			return errors.New("maintenance mode isn't on")
+		}
+		motd, err := leaveMaintenance(propertiesFile, stashFile, stash)
+		if err != nil {
+			return err
+		}
+		log.Printf("maintenance mode is off, with the MOTD restored to %q", motd)
+
+		reloadMOTD(*motdCommand, motd)
+		return nil
+
+	default:
+		return usage
+	}
+}
+
+// enterMaintenance applies the given property changes, stashing the values
+// they replace. Values already stashed by an earlier call are kept, so that
+// turning maintenance mode on again only changes the MOTD shown.
+func enterMaintenance(propertiesFile, stashFile string, stash *maintenanceStash, changes [][2]string) error {
+	p, err := loadProperties(propertiesFile)
+	if err != nil {
+		return err
+	}
+
+	if stash == nil {
+		stash = &maintenanceStash{Since: time.Now().UTC(), Properties: make(map[string]*string)}
+	}
+	for _, kv := range changes {
+		if _, ok := stash.Properties[kv[0]]; !ok {
+			if value, ok := p.get(kv[0]); ok {
+				stash.Properties[kv[0]] = &value
+			} else {
+				stash.Properties[kv[0]] = nil
+			}
+		}
+		p.set(kv[0], kv[1])
+	}
+
+	// Write the stash first, so that the original values survive a failure
+	// to write the properties.
+	data, err := json.MarshalIndent(stash, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(stashFile, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	return p.save(propertiesFile)
+}
+
+// leaveMaintenance restores the stashed properties, removing those that
+// weren't set before, and returns the restored MOTD.
+func leaveMaintenance(propertiesFile, stashFile string, stash *maintenanceStash) (string, error) {
+	p, err := loadProperties(propertiesFile)
+	if err != nil {
+		return "", err
+	}
+
+	keys := make([]string, 0, len(stash.Properties))
+	for key := range stash.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value := stash.Properties[key]; value != nil {
+			p.set(key, *value)
+		} else {
+			p.unset(key)
+		}
+	}
+	if err := p.save(propertiesFile); err != nil {
+		return "", err
+	}
+
+	motd, _ := p.get("motd")
+	return motd, os.Remove(stashFile)
+}
+
+// readMaintenanceStash reads the maintenance stash, returning nil if the
+// server isn't in maintenance mode.
+func readMaintenanceStash(filename string) (*maintenanceStash, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stash maintenanceStash
+	if err := json.Unmarshal(data, &stash); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if stash.Properties == nil {
+		stash.Properties = make(map[string]*string)
+	}
+
+	return &stash, nil
+}
+
+// reloadMOTD sets the MOTD of the running server with the given command, if
+// any, and otherwise notes that it is shown once the server restarts.
+func reloadMOTD(command, motd string) {
+	if command == "" {
+		log.Printf("the MOTD is shown once the server next starts, as it can't be changed while the server runs without -motd-command")
+		return
+	}
+
+	reloadOverRCON(fmt.Sprintf(command, motd))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// metricsPollInterval is how often the server is pinged for its player count.
+const metricsPollInterval = 15 * time.Second
+
+// metrics holds the values exposed on the metrics endpoint.
+type metrics struct {
+	mu            sync.Mutex
+	up            bool
+	playersOnline int
+	playersMax    int
+	started       time.Time
+	restarts      int
+	readyTimeouts int
+	lastBackup    time.Time
+	online        map[string]int
+	peakOnline    int
+}
+
+// serverMetrics is updated as the wrapper runs the server.
+var serverMetrics = &metrics{}
+
+// setStarted records when the server process started, or that it has exited if zero.
+func (m *metrics) setStarted(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.started = t
+}
+
+// setRestarts records the number of times the server has been restarted.
+func (m *metrics) setRestarts(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.restarts = n
+}
+
+// addReadyTimeout counts a start of the server that was killed for not
+// becoming ready in time.
+func (m *metrics) addReadyTimeout() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.readyTimeouts++
+}
+
+// setLastBackup records the time of the last successful backup.
+func (m *metrics) setLastBackup(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastBackup = t
+}
+
+// setStatus records the result of the last status ping.
+func (m *metrics) setStatus(status ServerStatus, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.up = err == nil
+	m.playersOnline = status.Players.Online
+	m.playersMax = status.Players.Max
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var uptime, lastBackup float64
+	if !m.started.IsZero() {
+		uptime = time.Since(m.started).Seconds()
+	}
+	if !m.lastBackup.IsZero() {
+		lastBackup = float64(m.lastBackup.Unix())
+	}
+
+	up := 0
+	if m.up {
+		up = 1
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "minecraft_up", "Whether the server responded to the last status ping.", "gauge", up)
+	writeMetric(w, "minecraft_players_online", "Number of players online.", "gauge", m.playersOnline)
+	writeMetric(w, "minecraft_players_max", "Maximum number of players.", "gauge", m.playersMax)
+	writeMetric(w, "minecraft_uptime_seconds", "Time since the server process started.", "gauge", uptime)
+	writeMetric(w, "minecraft_restarts_total", "Number of times the server has been restarted.", "counter", m.restarts)
+	writeMetric(w, "minecraft_ready_timeouts_total", "Number of starts of the server killed for not becoming ready in time.", "counter", m.readyTimeouts)
+	writeMetric(w, "minecraft_last_backup_timestamp_seconds", "Unix time of the last successful backup.", "gauge", lastBackup)
+	m.writePlayers(w)
+}
+
+// writeMetric writes a single metric with its help and type.
+func writeMetric(w http.ResponseWriter, name, help, typ string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+}
+
+// startMetricsServer serves the metrics, and the online players at /players,
+// on the given address and pings the server running in the given directory
+// for its player count until the returned server is shut down.
+func startMetricsServer(addr, dir string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", serverMetrics)
+	mux.HandleFunc("/players", serverMetrics.servePlayers)
+	srv := &http.Server{Handler: mux}
+
+	done := make(chan struct{})
+	srv.RegisterOnShutdown(func() { close(done) })
+
+	go func() {
+		ticker := time.NewTicker(metricsPollInterval)
+		defer ticker.Stop()
+		for {
+			serverMetrics.setStatus(ping(serverHost(dir), serverPort(dir)))
+
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	go srv.Serve(ln)
+
+	return srv, nil
+}
@@ -1,218 +1,1722 @@
 package main
 
 import (
+	"context"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"hash"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
 )
 
+var (
+	// quiet suppresses download progress reporting.
+	quiet bool
+
+	// downloadRetries is the number of attempts made to download a file.
+	downloadRetries int
+
+	// jsonLogs re-emits server output as JSON lines.
+	jsonLogs bool
+
+	// onReady is a shell command run once the server has started.
+	onReady string
+
+	// preStart is a shell command run before each start of the server,
+	// which isn't started if it fails, and postStop one run after each exit.
+	preStart, postStop string
+
+	// javaPath is the java executable used to run the server.
+	javaPath string
+
+	// cleanEnv starts the server with only the variables in cleanEnvVars
+	// of the wrapper's environment, and javaEnvVars are variables set in
+	// the server's environment.
+	cleanEnv    bool
+	javaEnvVars keyValueFlag
+
+	// stopTimeout is how long the server is given to stop before it is killed.
+	stopTimeout time.Duration
+
+	// jarCacheDir keeps a jar for each downloaded version so that switching
+	// back to a version doesn't download it again, or is empty to disable it.
+	jarCacheDir string
+
+	// forceDownload downloads the server even if the existing file
+	// validates, and starts downloads afresh rather than resuming them.
+	forceDownload bool
+
+	// allowedVersions are the version IDs, or path.Match patterns of them,
+	// that may be installed, or empty to allow any version.
+	allowedVersions []string
+
+	// allowSnapshot allows snapshot versions to be installed without asking
+	// for confirmation.
+	allowSnapshot bool
+
+	// manifestCache is the file the version manifest is cached in, or empty to disable caching.
+	manifestCache string
+
+	// manifestCacheTTL is how long a cached version manifest is used before it is refetched.
+	manifestCacheTTL time.Duration
+
+	// rconHost, rconPort, and rconPassword locate the server's RCON interface.
+	rconHost, rconPort, rconPassword string
+
+	// checksumAlgo forces the algorithm used to verify the server, detected from the checksum if empty.
+	checksumAlgo string
+)
+
+// errInterrupted is returned when a stop signal is received before the server starts.
+var errInterrupted = errors.New("interrupted before the server started")
+
+// errNoSpace is returned when there isn't enough disk space for a download.
+var errNoSpace = errors.New("not enough disk space")
+
+// diskSpaceMargin is the space left free, beyond the size of a download,
+// when checking that there is room for it.
+const diskSpaceMargin = 64 << 20
+
+// errForcedKill is returned when the server had to be killed after failing to stop in time.
+var errForcedKill = errors.New("server didn't stop in time and was killed")
+
+// errReadyTimeout is returned when the server had to be killed after failing to become ready in time.
+var errReadyTimeout = errors.New("server didn't become ready in time and was killed")
+
+// errKilled is returned when the server was killed by a second stop signal
+// while stopping.
+var errKilled = errors.New("server was killed while stopping")
+
+var (
+	// errChecksumMismatch is returned when a file's checksum doesn't validate.
+	errChecksumMismatch = errors.New("checksum doesn't validate")
+
+	// errNotJar is returned when a downloaded jar isn't a ZIP archive.
+	errNotJar = errors.New("downloaded file is not a valid jar")
+)
+
+// restartDelay is how long to wait before restarting a crashed server.
+const restartDelay = 5 * time.Second
+
+// eulaURL is the location of Mojang's Minecraft EULA.
+const eulaURL = "https://aka.ms/MinecraftEULA"
+
+// subcommands maps subcommand names to their implementations.
+var subcommands = map[string]func(args []string) error{
+	"clean":       runClean,
+	"healthcheck": runHealthcheck,
+	"init":        runInit,
+	"inspect":     runInspect,
+	"logs":        runLogs,
+	"maintenance": runMaintenance,
+	"op":          runOp,
+	"players":     runPlayers,
+	"properties":  runProperties,
+	"rollback":    runRollback,
+	"rcon":        runRCON,
+	"status":      runStatus,
+	"update":      runUpdate,
+	"uuid":        runUUID,
+	"verify":      runVerify,
+	"whitelist":   runWhitelist,
+}
+
+// exitWrapperError is the exit code used when the wrapper itself fails.
+// Otherwise, the wrapper exits with the exit code of the server, or 128 plus
+// the signal number if the server was killed by a signal.
+const exitWrapperError = 2
+
 func main() {
+	flag.Usage = usage
+
+	err := run()
+	if err == nil {
+		return
+	}
+	logError(err)
+
+	// Exit with the server's own exit code if it failed.
+	os.Exit(exitCode(err))
+}
+
+// exitCode returns the exit code the wrapper exits with for the given error
+// returned by run: the server's own exit code if it failed, 128 plus the
+// signal if it was killed, or exitWrapperError for the wrapper's own errors.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errForcedKill), errors.Is(err, errReadyTimeout), errors.Is(err, errKilled):
+		return 128 + int(syscall.SIGKILL)
+	case errors.Is(err, errUnhealthy):
+		return exitUnhealthy
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	default:
+		return exitWrapperError
+	}
+}
+
+// usage prints the wrapper's usage, including its exit codes.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [-- jvm-args]\n       %s <subcommand> [flags]\n\nFlags:\n", os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, `
+Subcommands:
+  clean        Removes cached jars of old versions, expired backups, and rotated logs.
+  healthcheck  Exits 0 if a running server responds to a ping, for container health checks.
+  init         Asks for the settings of a new server and writes its config file, server.properties, and eula.txt.
+  inspect      Prints the version, main class, and signatures embedded in the server jar.
+  logs         Prints the end of the server's log file, optionally following it.
+  maintenance  Turns maintenance mode on or off, replacing the MOTD until it is turned off.
+  op           Adds, removes, or lists server operators.
+  players      Lists the players online on a running server.
+  properties   Exports server.properties as JSON, or imports it from JSON.
+  rcon         Sends a command to a running server over RCON.
+  rollback     Switches the server back to the version installed before the current one.
+  status       Reports the status of a running server.
+  update       Downloads or checks for an update to the server without launching it.
+  uuid         Prints the UUID of a player.
+  verify       Checks the installed server against the checksum of its version.
+  whitelist    Adds, removes, or lists whitelisted players.
+
+Multiple servers:
+  -servers takes a JSON array of servers, each with a name and optionally a
+  dir (defaulting to the name), version, distribution, port, xms, xmx,
+  gc-preset, args, restart, max-restarts, backup-dir, backup-keep,
+  backup-interval, auto-restore, restart-schedule, restart-empty-only,
+  idle-timeout, wake-on-connect, dynamic-memory, crash-archive-dir,
+  ready-timeout, rcon-port, and rcon-password. A server without a version
+  uses the one in .mcversion in its dir, if any. A server's RCON interface
+  on localhost is used, as with -rcon-password, once both rcon keys are
+  given. Every server's output is prefixed with its name, and lines on
+  stdin are sent to the server named by their first word, e.g.
+  "survival say hi".
+
+GC presets:
+`)
+	printGCPresets(out)
+	fmt.Fprintf(out, `
+Environment:
+  MINECRAFT_JAVA_OPTS         Extra JVM options, placed before any given on the command line.
+  MINECRAFT_CONSOLE_PASSWORD  TCP console password used when -console-password is empty.
+  MINECRAFT_API_TOKEN         HTTP control API token used when -api-token is empty.
+  MINECRAFT_RCON_PASSWORD     RCON password used when -rcon-password, or -password for rcon, is empty.
+  MINECRAFT_WEBHOOK_URL       Webhook URL used when -webhook-url is empty.
+
+Exit codes:
+  0        The server stopped cleanly.
+  %d        The healthcheck subcommand got no response from the server.
+  %d        The wrapper failed, e.g. due to invalid flags or a failed download.
+  128+n    The server was killed by signal n, e.g. %d if it was killed after failing to stop or become ready in time, or by a second stop signal.
+  other    The server crashed with that exit code.
+`, exitUnhealthy, exitWrapperError, 128+int(syscall.SIGKILL))
+}
+
+// run runs the wrapper, returning the error that made it fail, if any.
+func run() error {
+	// Run a subcommand if one is given.
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			return subcommand(os.Args[2:])
+		}
+	}
+
+	config := flag.String("config", "", "JSON or TOML file whose keys set the flags of the same name. Flags given on the command line take precedence.")
+	writeConfigFile := flag.String("write-config", "", "Writes the effective configuration to the given JSON or TOML file and exits.")
 	filename := flag.String("filename", "server.jar", "Filename to use for the server.")
-	version := flag.String("version", "release", "Minecraft version to use. Must be 'release' (default), 'snapshot', or a specific version string.")
+	dir := flag.String("dir", ".", "Directory to download the server to and launch it from.")
+	bind := flag.String("bind", "", "IPv4 or IPv6 address for the server to listen on, written to server-ip in server.properties. Empty listens on all interfaces. Leaves server-ip as it is if not given.")
+	workdir := flag.String("workdir", "", "Directory to run the server in, where its world, logs, eula.txt, and server.properties are kept, instead of the current directory. The jar is still kept in -dir.")
+	version := flag.String("version", "release", "Minecraft version to use. Must be 'release', 'snapshot', or a specific version string. Defaults to the version in .mcversion in -dir if it exists, and to 'release' otherwise.")
+	distribution := flag.String("distribution", "vanilla", "Server distribution to use. Must be 'vanilla' (default), 'paper', or 'fabric'.")
+	edition := flag.String("edition", "java", "Minecraft edition of the server. Must be 'java' (default), or 'bedrock' to run the Bedrock dedicated server, which is downloaded as bedrock-server.zip unless -filename is given and extracted to run natively.")
 	doVersionCheck := flag.Bool("do-version-check", true, "Enables version checking.")
+	jarSHA1 := flag.String("jar-sha1", "", "SHA1 that the existing server jar, such as a custom build, must match before launch, instead of one from the version manifest. Requires -do-version-check=false.")
+	integrityInterval := flag.Duration("integrity-interval", 0, "Interval at which the server jar is verified against its recorded checksum while the server runs, logging a warning and posting an integrity-failure event to the webhook if it no longer matches.")
+	offline := flag.Bool("offline", false, "Launches an existing server without using the network, verifying it against its recorded checksum.")
+	flag.BoolVar(&quiet, "quiet", false, "Suppresses download progress output.")
+	flag.BoolVar(&jsonLogs, "json-logs", false, "Re-emits server output as JSON lines.")
+	colorMode := flag.String("color", "auto", "Colorizes warnings and errors in the server's output on stdout. Must be 'auto' (default) to colorize when stdout is a terminal, 'always', or 'never'.")
+	logFormat := flag.String("log-format", "plain", "Format of the wrapper's own log messages, but not the server's output. Must be 'plain' (default) or 'json' for JSON lines with a level.")
+	flag.BoolVar(&timestampOutput, "timestamp-output", false, "Prefixes each line of server output with an RFC 3339 timestamp.")
+	flag.StringVar(&preStart, "pre-start", "", "Shell command run in the server directory before each start of the server, which isn't started if it fails. The server is described in MINECRAFT_SERVER_DIR, MINECRAFT_SERVER_JAR, MINECRAFT_VERSION, and, with -servers, MINECRAFT_SERVER_NAME.")
+	flag.StringVar(&postStop, "post-stop", "", "Shell command run in the server directory each time the server exits, before it is restarted. It is passed the same variables as -pre-start, the exit code in MINECRAFT_EXIT_CODE, and 'stop' or 'crash' in MINECRAFT_STOP_REASON.")
+	flag.StringVar(&onReady, "on-ready", "", "Shell command run in the server directory once the server has started. It is passed the same variables as -pre-start and the startup duration in MINECRAFT_STARTUP_DURATION.")
+	flag.DurationVar(&stopTimeout, "stop-timeout", 30*time.Second, "Time to wait for the server to stop before killing it.")
+	flag.DurationVar(&httpTimeout, "http-timeout", httpTimeout, fmt.Sprintf("Timeout for HTTP requests. Downloads may take up to %d times as long.", downloadTimeoutFactor))
+	addProxyFlags(flag.CommandLine)
+	flag.Var(&certPins, "pin-cert", "Requires the certificates of download servers to have the given SHA-256 fingerprint, rejecting connections that don't, e.g. through an intercepting proxy. Given as [host=]fingerprint. Without a host, the pin applies to the downloads of files from every host without pins of its own, but not to webhooks or API lookups, which need a host. May be repeated.")
+	flag.DurationVar(&manifestCacheTTL, "manifest-cache-ttl", time.Hour, "Time the cached version manifest is used before it is refetched.")
+	flag.IntVar(&downloadRetries, "download-retries", 3, "Number of attempts made to download the server.")
+	flag.IntVar(&downloadParallelism, "download-parallelism", 1, "Number of byte ranges to split downloads into and fetch concurrently, if the server supports ranges. One downloads in a single stream.")
+	rateLimit := flag.String("download-rate-limit", "0", "Maximum download speed in bytes per second, e.g. 2M. Zero means unlimited.")
+	flag.BoolVar(&forceDownload, "force-download", false, "Downloads the server again even if the existing file's checksum matches.")
+	allowedVersionsList := flag.String("allowed-versions", "", "Comma-separated version IDs or patterns, e.g. 1.20.*, that the resolved version must match. Empty allows any version.")
+	flag.BoolVar(&allowSnapshot, "allow-snapshot", false, "Allows snapshot versions, which can irreversibly upgrade the world, without asking for confirmation. Required to use a snapshot when stdin isn't a terminal.")
+	flag.StringVar(&jarCacheDir, "jar-cache-dir", "", "Directory to keep a server-<version>.jar for each downloaded version in, which is copied to the launch filename when that version is requested again.")
+	flag.StringVar(&mirror, "mirror", "", "Base URL of a mirror to download the server from instead of the host given by the version information, keeping the path. The download is still verified against the original checksum.")
+	flag.StringVar(&checksumAlgo, "checksum-algo", "", "Checksum algorithm used to verify the server. Must be 'sha1', 'sha256', or empty (default) to detect it from the checksum.")
+	flag.StringVar(&javaPath, "java", "java", "Java executable used to run the server. Extra JVM options may be given in MINECRAFT_JAVA_OPTS.")
+	flag.BoolVar(&cleanEnv, "clean-env", false, "Starts the server with a minimal environment of PATH, HOME, and the locale rather than inheriting the wrapper's, with JAVA_HOME and PATH pointing at the java executable's runtime.")
+	flag.Var(&javaEnvVars, "env", "Sets a KEY=VALUE variable in the server's environment. May be repeated.")
+	manageJava := flag.Bool("manage-java", false, "Downloads a Java runtime matching the version's requirement from Adoptium and runs the server with it instead of -java. With -offline, a runtime already in -java-cache-dir is used.")
+	javaCacheDir := flag.String("java-cache-dir", defaultJavaCacheDir(), "Directory managed Java runtimes are kept in.")
+	skipJavaCheck := flag.Bool("skip-java-check", false, "Skips checking that the installed Java meets the version's requirement.")
+	xms := flag.String("xms", "", "Initial JVM heap size, e.g. 1G.")
+	xmx := flag.String("xmx", "", "Maximum JVM heap size, e.g. 2G. Defaults to half the system memory.")
+	gcPreset := flag.String("gc-preset", "none", "Preset of garbage collector options to launch the server with, placed before any other JVM options so they can override it. Must be 'none' (default), 'g1', or 'aikar'; see GC presets below.")
+	restart := flag.Bool("restart", false, "Restarts the server if it crashes.")
+	maxRestarts := flag.Int("max-restarts", 5, "Maximum number of restarts after crashes.")
+	restartScheduleSpec := flag.String("restart-schedule", "", "Restarts the server at the times given by a daily HH:MM time or a five-field cron spec, e.g. '0 4 * * *', warning players beforehand.")
+	restartEmptyOnly := flag.Bool("restart-empty-only", false, "Skips scheduled restarts while players are online. Requires -restart-schedule.")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Stops the server once no players have been online for this long. Zero disables the timeout.")
+	wakeOnConnect := flag.Bool("wake-on-connect", false, "Listens on the server port once the server is stopped for being idle, starting it again when a player tries to join. Requires -idle-timeout.")
+	dynamicMemory := flag.String("dynamic-memory", "", "Comma separated players=heap tiers, e.g. 0=2G,10=4G,25=6G, choosing the maximum heap at each scheduled restart or wake from idle by the peak number of players online before it. Requires -restart-schedule or -wake-on-connect.")
+	initProperties := flag.Bool("init-properties", false, "Adds default values for any missing keys to server.properties.")
+	port := flag.Int("port", 0, "Port to run the server on, written to server.properties.")
+	var setProperties keyValueFlag
+	flag.Var(&setProperties, "set", "Sets a key=value pair in server.properties. May be repeated.")
+	var listVersionsType optionalStringFlag
+	showInstalled := flag.Bool("show-version", false, "Prints the version of the installed server and exits.")
+	flag.Var(&listVersionsType, "list-versions", "Lists available versions and exits. May be set to 'release' or 'snapshot' to filter by type.")
+	var fetch fetchFlag
+	flag.Var(&fetch, "fetch", "Downloads a file before launch, given as url=dest with an optional #sha1=<sum> fragment on the URL for verification, e.g. https://example.com/icon.png#sha1=...=server-icon.png. May be repeated.")
+	pluginsFile := flag.String("plugins", "", "File listing plugin download URLs, each optionally followed by its SHA1, to download into plugins/ before launch.")
+	backupDir := flag.String("backup-dir", "", "Directory to back up the world to before starting the server.")
+	backupKeep := flag.Int("backup-keep", 0, "Number of backups to keep, removing the oldest. Zero keeps every backup.")
+	autoRestore := flag.Bool("auto-restore", false, "Restores the world from the latest good backup, one of a world the server was seen to load, and retries once if the server fails to start because the world appears damaged. Requires -backup-dir.")
+	backupInterval := flag.Duration("backup-interval", 0, "Interval between backups of the running server. Saving is paused over RCON if it is configured.")
+	flag.StringVar(&rconHost, "rcon-host", "localhost", "Host of the server's RCON interface.")
+	flag.StringVar(&rconPort, "rcon-port", "25575", "Port of the server's RCON interface.")
+	flag.StringVar(&rconPassword, "rcon-password", "", "RCON password of the server, or MINECRAFT_RCON_PASSWORD if empty. Features using RCON are disabled if neither is set.")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve Prometheus metrics on at /metrics, and the online players at /players, e.g. :9225.")
+	logFile := flag.String("log-file", "", "File to copy the server's output to.")
+	logMaxSize := flag.String("log-max-size", "10M", "Size at which the log file is rotated, e.g. 10M. Zero disables rotation.")
+	logKeep := flag.Int("log-keep", 5, "Number of rotated log files to keep.")
+	noStdin := flag.Bool("no-stdin", false, "Doesn't read console input from stdin, for running in the background under nohup or a service manager. Input can still be sent with -control-socket, -console-addr, or RCON.")
+	controlSocket := flag.String("control-socket", "", "Path of a Unix domain socket that forwards input to the server and streams its output back.")
+	consoleAddr := flag.String("console-addr", "", "Address to serve a password-protected TCP console on, e.g. :25580, which forwards input to the server and streams its output back.")
+	consolePassword := flag.String("console-password", "", "Password of the TCP console, or MINECRAFT_CONSOLE_PASSWORD if empty.")
+	var aliases keyValueFlag
+	flag.Var(&aliases, "alias", "Defines a NAME=COMMAND alias, entered as @NAME on the console, control socket, TCP console, or API to run its commands in order. Repeating a name adds a command to the alias, and a command may be @NAME to run another alias.")
+	apiAddr := flag.String("api-addr", "", "Address to serve the HTTP control API on, e.g. :25590, with POST /command, /stop, and /restart and GET /status. Requires -api-token.")
+	apiToken := flag.String("api-token", "", "Bearer token required by the HTTP control API, or MINECRAFT_API_TOKEN if empty.")
+	serveResourcePack := flag.String("serve-resource-pack", "", "Resource pack zip to serve to clients over HTTP, writing its URL and SHA1 to resource-pack and resource-pack-sha1 in server.properties.")
+	resourcePackAddr := flag.String("resource-pack-addr", ":25595", "Address to serve -serve-resource-pack on.")
+	resourcePackURL := flag.String("resource-pack-url", "", "Base URL clients reach -resource-pack-addr at, e.g. http://play.example.com:25595. Defaults to one with the host of -resource-pack-addr, which must then name one.")
+	printCommand := flag.Bool("print-command", false, "Prints the shell-quoted command the server would be launched with and exits.")
+	dryRun := flag.Bool("dry-run", false, "Prints the resolved version and the command the server would be launched with, without downloading or launching it.")
+	readyTimeout := flag.Duration("ready-timeout", 0, "Time the server is given to log that it is ready after starting before it is killed as hung, and restarted if -restart is set. Zero waits forever.")
+	crashArchiveDir := flag.String("crash-archive-dir", "", "Directory to copy the crash report written by each crash of the server to, so that it is kept if crash-reports is cleaned.")
+	flag.BoolVar(&webhookCrashReport, "webhook-crash-report", false, "Attaches the start of the crash report, if one was written, to crash events posted to the webhook as crash_report.")
+	webhookURL := flag.String("webhook-url", "", "URL to POST a JSON payload to when the server starts, becomes ready, crashes, or stops, or MINECRAFT_WEBHOOK_URL if empty.")
+	webhookTemplateText := flag.String("webhook-template", "", "Go template shaping the webhook payload, executed with the event's .Event, .Server, .Version, .ExitCode, and .Timestamp, e.g. {\"content\": {{json .Event}}} for Discord. The json function encodes a value as JSON.")
+	runAsUser := flag.String("run-as-user", "", "User, by name or uid, to run the server as when the wrapper runs as root. The server's directory, which must be dedicated to it, and the files the wrapper creates for it are given to the user.")
+	runAsGroup := flag.String("run-as-group", "", "Group, by name or gid, to run the server as. Defaults to the primary group of -run-as-user.")
+	servers := flag.String("servers", "", "JSON file describing several servers to run and supervise together instead of a single server.")
+	acceptEULAFlag := flag.Bool("accept-eula", false, "Accepts the Minecraft EULA ("+eulaURL+") by writing eula=true to eula.txt.")
 	flag.Parse()
 
-	if *doVersionCheck {
-		if err := getVersion(*version, *filename); err != nil {
-			log.Fatal(err)
+	// Flags set on the command line take precedence over the config file.
+	cmdline := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+
+	if *config != "" {
+		if err := loadConfig(flag.CommandLine, *config); err != nil {
+			return err
 		}
 	}
 
-	if err := startServer(*filename, flag.Args()); err != nil {
-		log.Fatal(err)
+	if err := setLogFormat(*logFormat); err != nil {
+		return err
+	}
+
+	if err := setDownloadRateLimit(*rateLimit); err != nil {
+		return err
+	}
+
+	if downloadParallelism < 1 {
+		return fmt.Errorf("invalid download parallelism %d, must be at least 1", downloadParallelism)
 	}
-}
 
-// startServer starts the server with the given filename and arguments.
-func startServer(filename string, args []string) error {
-	name := "java"
-	args = append(args, "-server", "-jar", filename, "nogui")
-	cmd := exec.Command(name, args...)
+	if err := setAllowedVersions(*allowedVersionsList); err != nil {
+		return err
+	}
+
+	if err := setCommandAliases(aliases); err != nil {
+		return err
+	}
+
+	if *writeConfigFile != "" {
+		return writeConfig(flag.CommandLine, *writeConfigFile)
+	}
 
-	in, err := cmd.StdinPipe()
+	// Secrets from the environment are applied after writing the config so
+	// that they aren't written to it.
+	if err := applySecretEnv(flag.CommandLine); err != nil {
+		return err
+	}
+
+	// A version pinned in the server directory applies unless one is given on
+	// the command line or in the config file, and otherwise a version that
+	// was rolled back to is kept.
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	bedrock := *edition == "bedrock"
+	switch {
+	case bedrock:
+		if set["distribution"] && *distribution != "bedrock" {
+			return errors.New("-distribution doesn't apply to -edition bedrock")
+		}
+		*distribution = "bedrock"
+		if !set["filename"] {
+			*filename = "bedrock-server.zip"
+		}
+	case *edition != "java":
+		return fmt.Errorf("invalid edition %q: must be 'java' or 'bedrock'", *edition)
+	case *distribution == "bedrock":
+		return errors.New("the bedrock distribution requires -edition bedrock")
+	}
+
+	if !set["version"] {
+		pinned, err := readPinnedVersion(*dir)
+		if err != nil {
+			return err
+		}
+		if pinned != "" {
+			*version = pinned
+		} else if rolledBack, ok := rolledBackVersion(filepath.Join(*dir, *filename)); ok {
+			log.Printf("staying on %s, which was rolled back to; give -version to change it", rolledBack.ID)
+			*version = rolledBack.ID
+			if !set["distribution"] {
+				*distribution = rolledBack.Distribution
+			}
+		}
+	}
+
+	httpClient = newHTTPClient(httpTimeout)
+	manifestCache = filepath.Join(*dir, "version_manifest.json")
+
+	// Stop signals cancel the server's preparation, then stop the server.
+	ctx, cancel := notifyStop()
+	defer cancel()
+
+	if listVersionsType.set {
+		if err := listVersions(ctx, listVersionsType.value); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *showInstalled {
+		jar, err := filepath.Abs(filepath.Join(*dir, *filename))
+		if err != nil {
+			return err
+		}
+		return showVersion(ctx, jar)
+	}
+
+	if err := validateGCPreset(*gcPreset); err != nil {
+		return err
+	}
+
+	color, err := useColor(*colorMode)
 	if err != nil {
 		return err
 	}
 
-	out, err := cmd.StdoutPipe()
+	for _, size := range []string{*xms, *xmx} {
+		if err := validateMemorySize(size); err != nil {
+			return err
+		}
+	}
+
+	if *port != 0 {
+		if err := validatePort(*port); err != nil {
+			return err
+		}
+	}
+
+	bindAddr, err := parseBindAddress(*bind)
+	if err != nil {
+		return err
+	}
+
+	if mirror != "" {
+		if err := validateMirror(mirror); err != nil {
+			return err
+		}
+	}
+
+	if *jarSHA1 != "" {
+		if *doVersionCheck {
+			return errors.New("-jar-sha1 requires -do-version-check=false")
+		}
+		if b, err := hex.DecodeString(*jarSHA1); err != nil || len(b) != sha1.Size {
+			return fmt.Errorf("invalid -jar-sha1 %q: must be a SHA1 in hex", *jarSHA1)
+		}
+	}
+
+	if *consoleAddr != "" && *consolePassword == "" {
+		return errors.New("-console-addr requires -console-password or MINECRAFT_CONSOLE_PASSWORD")
+	}
+
+	if *apiAddr != "" && *apiToken == "" {
+		return errors.New("-api-addr requires -api-token or MINECRAFT_API_TOKEN")
+	}
+
+	if checksumAlgo != "" && checksumAlgo != "sha1" && checksumAlgo != "sha256" {
+		return fmt.Errorf("invalid checksum algorithm %q", checksumAlgo)
+	}
+
+	var tmpl *template.Template
+	if *webhookTemplateText != "" {
+		var err error
+		tmpl, err = parseWebhookTemplate(*webhookTemplateText)
+		if err != nil {
+			return fmt.Errorf("invalid webhook template: %w", err)
+		}
+	}
+	setWebhook(*webhookURL, tmpl)
+
+	if *backupInterval > 0 && *backupDir == "" {
+		return errors.New("-backup-interval requires -backup-dir")
+	}
+
+	if *autoRestore && *backupDir == "" {
+		return errors.New("-auto-restore requires -backup-dir")
+	}
+
+	var restartSchedule *schedule
+	if *restartScheduleSpec != "" {
+		var err error
+		restartSchedule, err = parseSchedule(*restartScheduleSpec)
+		if err != nil {
+			return err
+		}
+	} else if *restartEmptyOnly {
+		return errors.New("-restart-empty-only requires -restart-schedule")
+	}
+
+	if *wakeOnConnect && *idleTimeout <= 0 {
+		return errors.New("-wake-on-connect requires -idle-timeout")
+	}
+
+	var heapTable []heapTier
+	if *dynamicMemory != "" {
+		if restartSchedule == nil && !*wakeOnConnect {
+			return errors.New("-dynamic-memory requires -restart-schedule or -wake-on-connect")
+		}
+		var err error
+		if heapTable, err = parseHeapTable(*dynamicMemory, *xms); err != nil {
+			return err
+		}
+	}
+
+	if bedrock {
+		switch {
+		case *manageJava:
+			return errors.New("-manage-java doesn't apply to -edition bedrock, which doesn't run on Java")
+		case heapTable != nil:
+			return errors.New("-dynamic-memory doesn't apply to -edition bedrock, which doesn't run on Java")
+		case *wakeOnConnect:
+			return errors.New("-wake-on-connect isn't supported with -edition bedrock, whose players connect over UDP")
+		case *backupDir != "", *autoRestore:
+			return errors.New("-backup-dir and -auto-restore aren't supported with -edition bedrock, which keeps its worlds elsewhere")
+		case *metricsAddr != "", *apiAddr != "":
+			return errors.New("-metrics-addr and -api-addr aren't supported with -edition bedrock, which can't be pinged over TCP")
+		case *servers != "":
+			return errors.New("-edition bedrock isn't supported with -servers")
+		}
+	}
+
+	if err := setRunAs(*runAsUser, *runAsGroup); err != nil {
+		return err
+	}
+
+	jar, err := filepath.Abs(filepath.Join(*dir, *filename))
 	if err != nil {
 		return err
 	}
 
-	// Start the server.
-	if err := cmd.Start(); err != nil {
+	srv := &server{
+		dir:            *workdir,
+		jar:            jar,
+		args:           flag.Args(),
+		xms:            *xms,
+		xmx:            *xmx,
+		gcPreset:       *gcPreset,
+		console:        serverConsole,
+		metrics:        serverMetrics,
+		restart:        *restart,
+		maxRestarts:    *maxRestarts,
+		backupDir:      *backupDir,
+		backupKeep:     *backupKeep,
+		backupInterval: *backupInterval,
+		rconAddr:       net.JoinHostPort(rconHost, rconPort),
+		rconPassword:   rconPassword,
+		autoRestore:    *autoRestore,
+
+		restartSchedule:  restartSchedule,
+		restartEmptyOnly: *restartEmptyOnly,
+		idleTimeout:      *idleTimeout,
+		wakeOnConnect:    *wakeOnConnect,
+		heapTable:        heapTable,
+
+		readyTimeout:      *readyTimeout,
+		crashArchiveDir:   *crashArchiveDir,
+		integrityInterval: *integrityInterval,
+		backupIntervals:   make(chan time.Duration, 1),
+	}
+	if bedrock {
+		if srv.executable, err = filepath.Abs(filepath.Join(*workdir, bedrockExecutable())); err != nil {
+			return err
+		}
+	}
+
+	if *dryRun && *servers == "" {
+		if err := printDryRun(ctx, *distribution, *version, *doVersionCheck && !*offline, srv); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *printCommand {
+		if path, err := resolveJava(javaPath); err == nil {
+			javaPath = path
+		}
+		fmt.Println(shellJoin(srv.command()))
+		return nil
+	}
+
+	if !*manageJava && !bedrock {
+		path, err := resolveJava(javaPath)
+		if err != nil {
+			return err
+		}
+		javaPath = path
+	}
+
+	// Copy the server's output to stdout and the log file, if any. Only
+	// stdout is colorized, so that the log file stays plain text.
+	var stdout io.Writer = os.Stdout
+	if color {
+		stdout = colorWriter{w: os.Stdout}
+	}
+	var output io.Writer = stdout
+	var logRotator *rotatingFile
+	if *logFile != "" {
+		maxSize, err := parseByteSize(*logMaxSize)
+		if err != nil {
+			return err
+		}
+
+		logRotator, err = openRotatingFile(*logFile, maxSize, *logKeep)
+		if err != nil {
+			return err
+		}
+		defer logRotator.Close()
+		output = io.MultiWriter(stdout, logRotator)
+	}
+
+	if *servers != "" {
+		switch {
+		case *dryRun:
+			return errors.New("-dry-run isn't supported with -servers")
+		case *controlSocket != "":
+			return errors.New("-control-socket isn't supported with -servers")
+		case *consoleAddr != "":
+			return errors.New("-console-addr isn't supported with -servers")
+		case *metricsAddr != "":
+			return errors.New("-metrics-addr isn't supported with -servers")
+		case *apiAddr != "":
+			return errors.New("-api-addr isn't supported with -servers")
+		case *serveResourcePack != "":
+			return errors.New("-serve-resource-pack isn't supported with -servers")
+		case *workdir != "":
+			return errors.New("-workdir isn't supported with -servers, whose servers each run in their dir")
+		case *manageJava:
+			return errors.New("-manage-java isn't supported with -servers")
+		}
+		return runServers(ctx, *servers, output, instanceOptions{
+			acceptEULA:     *acceptEULAFlag,
+			offline:        *offline,
+			doVersionCheck: *doVersionCheck,
+			skipJavaCheck:  *skipJavaCheck,
+			noStdin:        *noStdin,
+
+			integrityInterval: *integrityInterval,
+		})
+	}
+	srv.output = io.MultiWriter(output, serverConsole)
+
+	if err := prepareDir(*dir); err != nil {
 		return err
 	}
 
-	// Copy stdin to server input.
-	go func() {
-		if _, err := io.Copy(in, os.Stdin); err != nil {
-			log.Fatal(err)
+	if *workdir != "" {
+		if err := prepareDir(*workdir); err != nil {
+			return err
+		}
+	}
+
+	// The Java requirement is only known once the version is resolved.
+	requiredJava, checkJava := 0, false
+	if *jarSHA1 != "" {
+		if err := verifyCustomJar(jar, *jarSHA1); err != nil {
+			return err
+		}
+	} else if *offline {
+		if err := verifyOffline(jar); err != nil {
+			return err
+		}
+	} else if *doVersionCheck {
+		resolver, err := newResolver(*distribution)
+		if err != nil {
+			return err
+		}
+
+		resolved, err := getVersion(ctx, resolver, *version, jar)
+		if err != nil {
+			return err
+		}
+		srv.version = resolved.ID
+		requiredJava = resolved.JavaVersion
+		checkJava = !*skipJavaCheck && !bedrock
+	}
+
+	if bedrock {
+		if err := installBedrock(jar, *workdir); err != nil {
+			return err
+		}
+	}
+
+	if *manageJava {
+		path, err := installJava(ctx, *javaCacheDir, requiredJava, *offline)
+		if err != nil {
+			return err
+		}
+		javaPath = path
+	}
+
+	if checkJava {
+		if err := checkJavaVersion(javaPath, requiredJava); err != nil {
+			return err
+		}
+	}
+
+	if *acceptEULAFlag {
+		if err := acceptEULA(filepath.Join(*workdir, "eula.txt")); err != nil {
+			return err
+		}
+	} else {
+		accepted, err := checkEULA(filepath.Join(*workdir, "eula.txt"))
+		if err != nil {
+			return err
+		}
+		if !accepted {
+			return fmt.Errorf("the Minecraft EULA must be accepted before the server can start; read %s and rerun with -accept-eula or set eula=true in eula.txt", eulaURL)
+		}
+	}
+
+	if *initProperties || len(setProperties) > 0 {
+		if err := updateServerProperties(filepath.Join(*workdir, "server.properties"), *initProperties, setProperties); err != nil {
+			return err
+		}
+	}
+
+	if *port != 0 {
+		if err := setServerPort(filepath.Join(*workdir, "server.properties"), *port); err != nil {
+			return err
+		}
+	}
+
+	if set["bind"] {
+		if err := updateServerProperties(filepath.Join(*workdir, "server.properties"), false, [][2]string{{"server-ip", bindAddr}}); err != nil {
+			return err
 		}
-	}()
+	}
 
-	// Copy server output to stdout.
-	go func() {
-		if _, err := io.Copy(os.Stdout, out); err != nil {
-			log.Fatal(err)
+	var pack *resourcePack
+	if *serveResourcePack != "" {
+		var err error
+		if pack, err = newResourcePack(*serveResourcePack, *resourcePackAddr, *resourcePackURL); err != nil {
+			return err
+		}
+		if err := pack.writeProperties(filepath.Join(*workdir, "server.properties")); err != nil {
+			return err
 		}
-	}()
+	}
 
-	// Wait for server to exit.
-	if err := cmd.Wait(); err != nil {
+	if *pluginsFile != "" {
+		if err := installPlugins(ctx, *pluginsFile, filepath.Join(*workdir, "plugins")); err != nil {
+			return err
+		}
+	}
+
+	if err := fetchFiles(ctx, fetch, *workdir); err != nil {
 		return err
 	}
 
-	return nil
-}
+	if bedrock {
+		if err := checkBedrockPortFree(filepath.Join(*workdir, "server.properties")); err != nil {
+			return err
+		}
+	} else if err := checkPortFree(filepath.Join(*workdir, "server.properties")); err != nil {
+		return err
+	}
+
+	if err := chownToRunAs(filepath.Join(*workdir, "."), jar, *logFile); err != nil {
+		return err
+	}
 
-// getVersion obtains the server version with the given id and filename.
-func getVersion(id string, filename string) error {
-	// versionManifest contains the parsed JSON from the version manifest.
-	type versionManifest struct {
-		Latest struct {
-			Release  string
-			Snapshot string
+	if *controlSocket != "" {
+		ln, err := listenControlSocket(*controlSocket)
+		if err != nil {
+			return err
 		}
-		Versions []struct {
-			ID  string
-			URL string
+		defer ln.Close()
+	}
+
+	if *consoleAddr != "" {
+		ln, err := listenConsole(*consoleAddr, *consolePassword)
+		if err != nil {
+			return err
 		}
+		defer ln.Close()
 	}
 
-	// versionJSON contains the parsed JSON from the version information.
-	type versionJSON struct {
-		Downloads struct {
-			Server struct {
-				SHA1 string
-				URL  string
+	// Copy stdin to the server's console.
+	if !*noStdin {
+		go func() {
+			if err := serverConsole.readInput(os.Stdin); err != nil {
+				log.Printf("failed to read stdin: %v", err)
 			}
+		}()
+	}
+
+	if *metricsAddr != "" {
+		metricsServer, err := startMetricsServer(*metricsAddr, *workdir)
+		if err != nil {
+			return err
 		}
+		defer metricsServer.Shutdown(context.Background())
 	}
 
-	// Get the version manifest.
-	var manifest versionManifest
-	if err := getJSON("https://launchermeta.mojang.com/mc/game/version_manifest.json", &manifest); err != nil {
-		return err
+	if pack != nil {
+		packServer, err := pack.serve(*resourcePackAddr)
+		if err != nil {
+			return err
+		}
+		defer packServer.Shutdown(context.Background())
+	}
+
+	if *apiAddr != "" {
+		srv.restartRequests = make(chan struct{})
+		apiServer, err := startAPIServer(*apiAddr, *apiToken, srv, cancel)
+		if err != nil {
+			return err
+		}
+		defer apiServer.Shutdown(context.Background())
 	}
 
-	// Map 'release' and 'snapshot' keywords to the latest versions.
-	if id == "release" {
-		id = manifest.Latest.Release
-	} else if id == "snapshot" {
-		id = manifest.Latest.Snapshot
+	// Apply changes to the config file on SIGHUP.
+	if *config != "" {
+		go reloadConfigOnHangup(*config, cmdline, func(name, value string) error {
+			return reloadSetting(srv, logRotator, name, value)
+		})
 	}
 
-	// Test if the given version is listed in the manifest.
-	for _, v := range manifest.Versions {
-		if id == v.ID {
-			// Obtain the information for the given version.
-			var json versionJSON
-			if err := getJSON(v.URL, &json); err != nil {
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+
+	// Run the server, restarting it after crashes if enabled.
+	return srv.run(ctx.Done())
+}
+
+// reloadSetting applies a changed hot-reloadable setting to the running
+// server and log file, which is nil if the output isn't logged to a file.
+func reloadSetting(srv *server, logRotator *rotatingFile, name, value string) error {
+	switch name {
+	case "backup-interval":
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if srv.backupDir == "" {
+			return errors.New("-backup-interval requires -backup-dir")
+		}
+		srv.setBackupInterval(interval)
+	case "webhook-url":
+		_, tmpl := currentWebhook()
+		setWebhook(value, tmpl)
+	case "webhook-template":
+		var tmpl *template.Template
+		if value != "" {
+			var err error
+			if tmpl, err = parseWebhookTemplate(value); err != nil {
 				return err
 			}
+		}
+		url, _ := currentWebhook()
+		setWebhook(url, tmpl)
+	case "log-max-size":
+		maxSize, err := parseByteSize(value)
+		if err != nil {
+			return err
+		}
+		if logRotator == nil {
+			return errors.New("-log-max-size requires -log-file")
+		}
+		logRotator.setMaxSize(maxSize)
+	}
+
+	return nil
+}
+
+// printDryRun prints the version that would be downloaded, if resolve is
+// set, and the command the server would be launched with.
+func printDryRun(ctx context.Context, distribution, id string, resolve bool, srv *server) error {
+	if resolve {
+		resolver, err := newResolver(distribution)
+		if err != nil {
+			return err
+		}
+
+		version, err := resolver.Resolve(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Version:  %s (%s %s)\n", version.ID, distribution, version.Type)
+		fmt.Printf("URL:      %s\n", version.URL)
+		fmt.Printf("Checksum: %s\n", version.Checksum)
+	}
+
+	fmt.Printf("Jar:      %s\n", srv.jar)
+	fmt.Printf("Command:  %s\n", shellJoin(srv.command()))
+	if cleanEnv {
+		var names []string
+		for _, kv := range javaEnv(cleanEnv, javaEnvVars) {
+			name, _, _ := strings.Cut(kv, "=")
+			names = append(names, name)
+		}
+		fmt.Printf("Env:      %s\n", strings.Join(names, " "))
+	}
+
+	return nil
+}
+
+// parseByteSize parses a size such as 512K, 10M, or 1G into bytes.
+func parseByteSize(s string) (int64, error) {
+	multiplier := int64(1)
+	number := strings.ToUpper(strings.TrimSpace(s))
+	switch {
+	case strings.HasSuffix(number, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(number, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(number, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		number = number[:len(number)-1]
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return n * multiplier, nil
+}
+
+// prepareDir creates the given directory if needed and ensures it is writable.
+func prepareDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Test that files can be created in the directory.
+	file, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	file.Close()
+
+	return os.Remove(file.Name())
+}
+
+// checkEULA reports whether the given EULA file exists and contains eula=true.
+func checkEULA(filename string) (bool, error) {
+	p, err := loadProperties(filename)
+	if err != nil {
+		return false, err
+	}
 
-			// Get the server from the given filename.
-			if _, err := os.Stat(filename); os.IsNotExist(err) {
-				// Download the file if it doesn't exist.
-				if err := downloadFile(filename, json.Downloads.Server.URL); err != nil {
-					return err
-				}
-
-				if err := verifySHA1(filename, json.Downloads.Server.SHA1); err != nil {
-					return err
-				}
-			} else {
-				// Open the file if it exists.
-				file, err := os.Open(filename)
-				if err != nil {
-					return err
-				}
-				defer file.Close()
-
-				// Attempt to download the file if SHA1 doesn't validate.
-				if err := verifySHA1(filename, json.Downloads.Server.SHA1); err != nil {
-					if err := downloadFile(filename, json.Downloads.Server.URL); err != nil {
-						return err
-					}
-
-					// Verify the SHA1 of the newly downloaded file.
-					if err := verifySHA1(filename, json.Downloads.Server.SHA1); err != nil {
-						return err
-					}
-				}
+	value, _ := p.get("eula")
+	return strings.EqualFold(value, "true"), nil
+}
+
+// acceptEULA writes eula=true to the given EULA file, preserving any other lines.
+func acceptEULA(filename string) error {
+	p, err := loadProperties(filename)
+	if err != nil {
+		return err
+	}
+	p.set("eula", "true")
+
+	return p.save(filename)
+}
+
+// manifestURL is the location of Mojang's version manifest.
+const manifestURL = "https://launchermeta.mojang.com/mc/game/version_manifest_v2.json"
+
+// versionManifest contains the parsed JSON from the version manifest.
+type versionManifest struct {
+	Latest struct {
+		Release  string
+		Snapshot string
+	}
+	Versions []manifestVersion
+}
+
+// manifestVersion is a single version listed in the version manifest.
+type manifestVersion struct {
+	ID          string
+	Type        string
+	URL         string
+	SHA1        string
+	ReleaseTime time.Time
+}
+
+// getManifest returns the version manifest, reading it from the cache while
+// it is fresh and falling back to a stale cache if it can't be fetched.
+func getManifest(ctx context.Context) (versionManifest, error) {
+	var manifest versionManifest
+
+	// Use the cached manifest if it is younger than the TTL.
+	info, statErr := os.Stat(manifestCache)
+	if manifestCache != "" && statErr == nil && time.Since(info.ModTime()) < manifestCacheTTL {
+		if data, err := os.ReadFile(manifestCache); err == nil && json.Unmarshal(data, &manifest) == nil {
+			return manifest, nil
+		}
+	}
+
+	data, err := getBytes(ctx, manifestURL)
+	if err != nil {
+		// Fall back to a stale cache rather than failing, unless the
+		// wrapper is stopping.
+		if ctx.Err() != nil {
+			return manifest, ctx.Err()
+		}
+		if manifestCache != "" && statErr == nil {
+			cached, readErr := os.ReadFile(manifestCache)
+			if readErr == nil && json.Unmarshal(cached, &manifest) == nil {
+				log.Printf("warning: using cached version manifest from %s: %v", info.ModTime().Format(time.RFC3339), err)
+				return manifest, nil
 			}
+		}
+		return manifest, err
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, err
+	}
+
+	// Refresh the cache, which is best effort.
+	if manifestCache != "" {
+		if err := os.WriteFile(manifestCache, data, 0644); err != nil {
+			log.Printf("warning: failed to cache version manifest: %v", err)
+		}
+	}
+
+	return manifest, nil
+}
+
+// getVersion obtains the server version with the given id and filename
+// using the given resolver, returning the resolved version.
+func getVersion(ctx context.Context, resolver VersionResolver, id string, filename string) (resolvedVersion, error) {
+	version, err := resolver.Resolve(ctx, id)
+	if err != nil {
+		return version, err
+	}
+	log.Printf("resolved %s version %q to %s", version.Distribution, id, version.ID)
+
+	if err := checkAllowedVersion(version.ID); err != nil {
+		return version, err
+	}
+
+	if err := confirmSnapshot(version); err != nil {
+		return version, err
+	}
+
+	if _, err := installVersion(ctx, &version, filename); err != nil {
+		return version, err
+	}
+
+	recordVersion(version, filename)
+	return version, nil
+}
+
+// setAllowedVersions sets the allowed versions from a comma-separated list
+// of version IDs and patterns.
+func setAllowedVersions(list string) error {
+	allowedVersions = nil
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid allowed version %q: %w", pattern, err)
+		}
+		allowedVersions = append(allowedVersions, pattern)
+	}
+
+	return nil
+}
 
+// checkAllowedVersion returns an error listing the allowed versions if the
+// given version ID doesn't match any of them.
+func checkAllowedVersion(id string) error {
+	if len(allowedVersions) == 0 {
+		return nil
+	}
+
+	for _, pattern := range allowedVersions {
+		if ok, _ := path.Match(pattern, id); ok {
 			return nil
 		}
 	}
 
-	return errors.New("invalid version")
+	return fmt.Errorf("version %s isn't allowed; allowed versions are %s", id, strings.Join(allowedVersions, ", "))
+}
+
+// confirmSnapshot asks for confirmation before a snapshot version is used,
+// as snapshots can upgrade the world in ways that can't be undone. Without
+// -allow-snapshot, a snapshot is refused when stdin isn't a terminal.
+func confirmSnapshot(version resolvedVersion) error {
+	if version.Type != "snapshot" || allowSnapshot {
+		return nil
+	}
+
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("%s is a snapshot, which can irreversibly upgrade the world; rerun with -allow-snapshot to use it", version.ID)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s is a snapshot, which can irreversibly upgrade the world. Back it up first. Continue? [y/N] ", version.ID)
+	answer, err := readLine(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+		return fmt.Errorf("not using snapshot %s", version.ID)
+	}
+
+	return nil
+}
+
+// readLine reads a line from r a byte at a time, so that nothing after it is
+// consumed before the console takes over stdin.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if errors.Is(err, io.EOF) {
+			return string(line), nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
+// installVersion gets the given resolved version into the given filename,
+// downloading it if it is missing or its checksum doesn't validate, and
+// reports whether it was downloaded. The version's checksum is filled in
+// from the download if it has none.
+func installVersion(ctx context.Context, version *resolvedVersion, filename string) (bool, error) {
+	// Download from the mirror, if any, still verifying against the
+	// checksum published by the original source.
+	url, err := mirrorURL(version.URL)
+	if err != nil {
+		return false, err
+	}
+
+	// Reuse a previously downloaded jar of the same version if it validates.
+	if jarCacheDir != "" && version.Checksum != "" {
+		return installCachedVersion(ctx, version, filename, url)
+	}
+
+	downloaded := false
+	if sum, ok := recordedBedrockZip(filename, *version); ok {
+		version.Checksum = sum
+	} else if version.Checksum == "" {
+		log.Printf("warning: no checksum available for %s, skipping verification", version.ID)
+		if err := downloadFile(ctx, filename, url, "", "", true); err != nil {
+			return false, err
+		}
+		downloaded = true
+
+		if version.Checksum, err = fileChecksum(filename, "sha1"); err != nil {
+			return downloaded, err
+		}
+	} else if forceDownload || verifyChecksum(filename, checksumAlgo, version.Checksum) != nil {
+		if err := downloadFile(ctx, filename, url, checksumAlgo, version.Checksum, true); err != nil {
+			return false, err
+		}
+		downloaded = true
+	}
+
+	// Record the verified checksum for offline use.
+	return downloaded, writeChecksumFile(filename, version.Checksum)
+}
+
+// verifyCustomJar verifies the server with the given filename against the
+// given SHA1, for jars that aren't in the version manifest, and records the
+// checksum for offline use.
+func verifyCustomJar(filename, checksum string) error {
+	if _, err := os.Stat(filename); err != nil {
+		return fmt.Errorf("-jar-sha1 requires an existing server: %w", err)
+	}
+
+	if err := verifySHA1(filename, checksum); err != nil {
+		return fmt.Errorf("%s doesn't match -jar-sha1 %s: %w", filename, checksum, err)
+	}
+	log.Printf("verified %s against -jar-sha1", filename)
+
+	return writeChecksumFile(filename, strings.ToLower(checksum))
+}
+
+// verifyOffline verifies the server with the given filename against its
+// recorded checksum without using the network. Verification is skipped with
+// a warning if no checksum has been recorded.
+func verifyOffline(filename string) error {
+	if _, err := os.Stat(filename); err != nil {
+		return fmt.Errorf("offline mode requires an existing server: %w", err)
+	}
+
+	checksum, err := os.ReadFile(filename + ".sha1")
+	if os.IsNotExist(err) {
+		log.Printf("warning: no recorded checksum for %s, skipping verification", filename)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// The recorded checksum may be of either algorithm, whatever -checksum-algo
+	// is now.
+	return verifyChecksum(filename, "", strings.TrimSpace(string(checksum)))
 }
 
 // getJSON parses JSON from a given url into the given target interface.
-func getJSON(url string, target interface{}) error {
-	resp, err := http.Get(url)
+func getJSON(ctx context.Context, url string, target interface{}) error {
+	data, err := getBytes(ctx, url)
 	if err != nil {
 		return err
 	}
+
+	return json.Unmarshal(data, target)
+}
+
+// getBytes returns the body of the response from the given url.
+func getBytes(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Asking for compression explicitly turns off the transport's own gzip
+	// handling, so that deflate is accepted too and both are decoded alike.
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
 
-	return json.NewDecoder(resp.Body).Decode(target)
+	if err := checkResponse(resp, url); err != nil {
+		return nil, err
+	}
+
+	body, err := decodeBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("decoding response from %s: %w", url, err)
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+// statusError is returned for responses with an unexpected status.
+type statusError struct {
+	StatusCode int
+	URL        string
+	Body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d fetching %s: %s", e.StatusCode, e.URL, e.Body)
+}
+
+// checkResponse returns a descriptive error, including the start of the body, if the response status isn't 200.
+func checkResponse(resp *http.Response, url string) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &statusError{StatusCode: resp.StatusCode, URL: url, Body: strings.TrimSpace(string(body))}
 }
 
 // verifySHA1 verifies a file's SHA1 against the given checksum.
 func verifySHA1(filename, checksum string) error {
+	return verifyChecksum(filename, "sha1", checksum)
+}
+
+// verifyChecksum verifies a file's checksum using the given algorithm, detected from the checksum length if empty.
+func verifyChecksum(filename, algo, checksum string) error {
+	if algo == "" {
+		algo = detectChecksumAlgo(checksum)
+	}
+
+	sum, err := fileChecksum(filename, algo)
+	if err != nil {
+		return err
+	}
+
+	// Test if the hash matches the checksum.
+	if !strings.EqualFold(sum, checksum) {
+		return fmt.Errorf("%s %w", algo, errChecksumMismatch)
+	}
+
+	return nil
+}
+
+// detectChecksumAlgo returns the algorithm matching the length of a hex checksum.
+func detectChecksumAlgo(checksum string) string {
+	switch len(checksum) {
+	case sha256.Size * 2:
+		return "sha256"
+	default:
+		return "sha1"
+	}
+}
+
+// fileChecksum returns the hex encoded hash of a file using the given algorithm.
+func fileChecksum(filename, algo string) (string, error) {
+	var h hash.Hash
+	switch algo {
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+
 	// Try to open the file with the given filename.
 	file, err := os.Open(filename)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer file.Close()
 
-	// Generate a SHA1 hash for the file.
-	hash := sha1.New()
-	if _, err := io.Copy(hash, file); err != nil {
+	// Generate a hash for the file.
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// installCachedVersion gets the given resolved version into the given
+// filename by way of the jar cache, downloading it into the cache from url
+// only if the cache has no valid copy, and reports whether it was downloaded.
+func installCachedVersion(ctx context.Context, version *resolvedVersion, filename, url string) (bool, error) {
+	cached := filepath.Join(jarCacheDir, cachedJarName(version.Distribution, version.ID))
+	if err := os.MkdirAll(jarCacheDir, 0755); err != nil {
+		return false, err
+	}
+
+	// Keep a copy of an already valid jar so that it can be switched back to.
+	if err := verifyChecksum(filename, checksumAlgo, version.Checksum); err == nil && !forceDownload {
+		if _, err := os.Stat(cached); os.IsNotExist(err) {
+			if err := copyFile(filename, cached); err != nil {
+				return false, err
+			}
+		}
+		return false, writeChecksumFile(filename, version.Checksum)
+	}
+
+	downloaded := false
+	if err := verifyChecksum(cached, checksumAlgo, version.Checksum); err == nil && !forceDownload {
+		log.Printf("using cached %s", cached)
+	} else {
+		if err := downloadFile(ctx, cached, url, checksumAlgo, version.Checksum, true); err != nil {
+			return false, err
+		}
+		downloaded = true
+	}
+
+	if err := copyFile(cached, filename); err != nil {
+		return downloaded, err
+	}
+
+	return downloaded, writeChecksumFile(filename, version.Checksum)
+}
+
+// writeChecksumFile records the verified checksum of the given file for offline use.
+func writeChecksumFile(filename, checksum string) error {
+	return os.WriteFile(filename+".sha1", []byte(checksum+"\n"), 0644)
+}
+
+// copyFile copies src to dst, replacing dst only once the copy is complete.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
 		return err
 	}
+	defer in.Close()
 
-	// Test if the hash matches the checksum.
-	sha1 := hex.EncodeToString(hash.Sum(nil))
-	if sha1 != checksum {
-		return errors.New("sha1 checksum doesn't validate")
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
 	}
 
-	return nil
+	return os.Rename(tmp, dst)
 }
 
-// downloadFile downloads a file from the given url into the current directory.
-func downloadFile(filename, url string) error {
-	// Try to create the file with the given filename.
-	file, err := os.Create(filename)
+// downloadFile downloads a file from the given url to the given filename,
+// retrying with exponential backoff. The download is written to a temporary
+// file that is verified against the given checksum, unless it is empty, using
+// the given algorithm, or one detected from the checksum if that is empty, and
+// checked to be a ZIP archive if zipped is set, as jars are. It is only then
+// renamed into place so an interrupted download never replaces a working
+// file. An interrupted download is resumed by the next attempt, or by a later
+// run as long as the url and the server's validator for the file are the
+// same.
+func downloadFile(ctx context.Context, filename, url, algo, checksum string, zipped bool) error {
+	tmp := filename + ".tmp"
+	if forceDownload {
+		removePartial(tmp)
+	}
+	attempts := max(downloadRetries, 1)
+	backoff := time.Second
+	segmented := downloadParallelism > 1
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if segmented {
+			err = downloadSegmented(ctx, tmp, url)
+		}
+		if !segmented || errors.Is(err, errSingleStream) {
+			segmented = false
+			err = downloadOnce(ctx, tmp, url, checksum != "")
+		}
+		if err == nil && zipped {
+			err = verifyZip(tmp)
+		}
+		if err == nil && checksum != "" {
+			err = verifyChecksum(tmp, algo, checksum)
+		}
+
+		// Start over if the file is corrupt, since resuming can't fix it.
+		if errors.Is(err, errChecksumMismatch) || errors.Is(err, errNotJar) {
+			removePartial(tmp)
+		}
+		if err == nil {
+			os.Remove(tmp + partialSourceSuffix)
+			return os.Rename(tmp, filename)
+		}
+		if ctx.Err() != nil || errors.Is(err, errNoSpace) || errors.Is(err, errPinMismatch) ||
+			errors.Is(err, errTooManyRedirects) || errors.Is(err, errInsecureRedirect) {
+			return err
+		}
+
+		// Wait before the next attempt, doubling the delay each time.
+		if attempt < attempts {
+			log.Printf("download attempt %d/%d failed: %v; retrying in %s", attempt, attempts, err, backoff)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+
+	return fmt.Errorf("download of %s failed after %d attempts: %w", url, attempts, err)
+}
+
+// verifyZip returns an error if the given file doesn't start with the ZIP
+// magic bytes, which catches error pages saved in place of a jar.
+func verifyZip(filename string) error {
+	file, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != "PK\x03\x04" {
+		return errNotJar
+	}
+
+	return nil
+}
+
+// partialSourceSuffix is appended to the name of a partial download for the
+// file recording where it came from.
+const partialSourceSuffix = ".source"
+
+// partialSource records the url a partial download came from and the
+// server's validator for it, an ETag or Last-Modified date, so that it is
+// only resumed from the same version of the same file.
+type partialSource struct {
+	URL       string `json:"url"`
+	Validator string `json:"validator"`
+}
+
+// readPartialSource returns the source recorded for the given partial
+// download, or the zero value if there is none.
+func readPartialSource(filename string) partialSource {
+	var source partialSource
+	if data, err := os.ReadFile(filename + partialSourceSuffix); err == nil {
+		json.Unmarshal(data, &source)
+	}
+
+	return source
+}
+
+// writePartialSource records the source of the given partial download.
+func writePartialSource(filename string, source partialSource) error {
+	data, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename+partialSourceSuffix, data, 0644)
+}
+
+// removePartial removes the given partial download and its source.
+func removePartial(filename string) {
+	os.Remove(filename)
+	os.Remove(filename + partialSourceSuffix)
+}
+
+// responseValidator returns the validator that an If-Range header can use
+// to resume the response's file: its ETag if it is a strong one, and
+// otherwise its Last-Modified date. It is empty if the server sent neither.
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+
+	return resp.Header.Get("Last-Modified")
+}
+
+// downloadOnce makes a single attempt at downloading a file from the given
+// url to the given filename, resuming from the end of the file if it exists,
+// came from the same url and version of the file, and the server supports
+// range requests. Since a server that can't satisfy the range may have a
+// smaller file, the existing file is only taken as complete then if it is
+// verified, which the caller does when verified is set.
+func downloadOnce(ctx context.Context, filename, url string, verified bool) error {
+	var offset int64
+	source := readPartialSource(filename)
+	if info, err := os.Stat(filename); err == nil {
+		offset = info.Size()
+	}
+	if offset > 0 && (source.URL != url || source.Validator == "") {
+		log.Printf("discarding partial download of %s that may be of another file", url)
+		removePartial(filename)
+		offset = 0
+	}
+
 	// Get the response from the given url.
-	resp, err := http.Get(url)
+	resp, cancel, err := getDownload(ctx, url, offset, -1, source.Validator)
 	if err != nil {
 		return err
 	}
+	defer cancel()
 	defer resp.Body.Close()
+	logRedirect(resp, url)
+
+	// Append to the file if the server honored the range, otherwise start over.
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			removePartial(filename)
+			return fmt.Errorf("resuming %s: server sent range %q instead of from byte %d", url, resp.Header.Get("Content-Range"), offset)
+		}
+		log.Printf("resuming download of %s from byte %d", url, offset)
+		flags |= os.O_APPEND
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		if !verified {
+			removePartial(filename)
+			return fmt.Errorf("resuming %s: server can't send from byte %d", url, offset)
+		}
+		// The file is already complete; its checksum is verified by the caller.
+		return nil
+	default:
+		if err := checkResponse(resp, url); err != nil {
+			return err
+		}
+		offset = 0
+		flags |= os.O_TRUNC
+		if err := writePartialSource(filename, partialSource{URL: url, Validator: responseValidator(resp)}); err != nil {
+			return err
+		}
+	}
 
-	// Copy the response body into the file.
-	_, err = io.Copy(file, resp.Body)
+	// Make sure the rest of the file fits, where the free space can be read.
+	if resp.ContentLength > 0 {
+		if err := checkDiskSpace(filepath.Dir(filename), resp.ContentLength); err != nil {
+			return err
+		}
+	}
+
+	// Try to open the file with the given filename.
+	file, err := os.OpenFile(filename, flags, 0644)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	return nil
+	// Copy the response body into the file, reporting progress unless quiet.
+	var dst io.Writer = file
+	if !quiet {
+		progress := &progressWriter{written: offset, total: -1}
+		if resp.ContentLength >= 0 {
+			progress.total = offset + resp.ContentLength
+		}
+		defer progress.finish()
+		dst = io.MultiWriter(file, progress)
+	}
+
+	// Throttle the download, if limited, ahead of the progress reporting so
+	// that it reports the limited rate.
+	var src io.Reader = resp.Body
+	if downloadRateLimit > 0 {
+		src = newRateLimitedReader(ctx, resp.Body, downloadRateLimit)
+	}
+
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		return err
+	}
+
+	// Test that the whole body was received.
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("truncated download: got %d of %d bytes", n, resp.ContentLength)
+	}
+
+	return file.Close()
+}
+
+// checkDiskSpace returns an error if the filesystem holding the given
+// directory doesn't have room for size more bytes plus a safety margin. The
+// check is skipped where the available space can't be read.
+func checkDiskSpace(dir string, size int64) error {
+	available, ok := availableSpace(dir)
+	if !ok || available >= size+diskSpaceMargin {
+		return nil
+	}
+
+	return fmt.Errorf("%w in %s: the download needs %d bytes plus a %d byte margin but only %d are available", errNoSpace, dir, size, diskSpaceMargin, available)
+}
+
+// progressWriter counts the bytes written to it and periodically reports them to stderr.
+type progressWriter struct {
+	total   int64
+	written int64
+	last    time.Time
+
+	// logged is the last quarter of the download logged in the JSON log format.
+	logged int64
+}
+
+// Write counts the given bytes, reporting progress at most every 500ms.
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if time.Since(p.last) >= 500*time.Millisecond {
+		p.last = time.Now()
+		p.report()
+	}
+
+	return len(b), nil
+}
+
+// report prints the current progress as a percentage, or as a byte count if
+// the total is unknown. In the JSON log format, progress is instead logged
+// at each quarter of the download.
+func (p *progressWriter) report() {
+	if wrapperLog != nil {
+		if quarter := p.written * 4 / max(p.total, 1); p.total > 0 && quarter > p.logged {
+			p.logged = quarter
+			log.Printf("downloaded %d%% (%d/%d bytes)", quarter*25, p.written, p.total)
+		}
+		return
+	}
+
+	if p.total > 0 {
+		fmt.Fprintf(os.Stderr, "\rDownloading: %d%% (%d/%d bytes)", p.written*100/p.total, p.written, p.total)
+	} else {
+		fmt.Fprintf(os.Stderr, "\rDownloading: %d bytes", p.written)
+	}
+}
+
+// finish prints the final progress and ends the progress line.
+func (p *progressWriter) finish() {
+	if wrapperLog != nil && p.total <= 0 {
+		log.Printf("downloaded %d bytes", p.written)
+		return
+	}
+
+	p.report()
+	if wrapperLog == nil {
+		fmt.Fprintln(os.Stderr)
+	}
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/subtle"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// consoleAuthTimeout is how long a console client has to send the password.
+	consoleAuthTimeout = 10 * time.Second
+
+	// consoleAuthFailures is the number of failed logins allowed from an
+	// address within consoleAuthWindow before further connections from it
+	// are dropped until the window passes.
+	consoleAuthFailures = 5
+	consoleAuthWindow   = time.Minute
+
+	// consoleAuthDelay is how long a failed login waits before it is dropped,
+	// slowing down guessing.
+	consoleAuthDelay = time.Second
+
+	// consoleAuthPending is the number of logins an address may have in
+	// progress at once.
+	consoleAuthPending = 2
+)
+
+// authLimiter tracks recent failed logins, and logins in progress, by address.
+type authLimiter struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time
+	pending  map[string]int
+}
+
+// newAuthLimiter returns a limiter with no logins recorded.
+func newAuthLimiter() *authLimiter {
+	return &authLimiter{failures: make(map[string][]time.Time), pending: make(map[string]int)}
+}
+
+// recent returns the failures of host within the window, forgetting older ones.
+func (l *authLimiter) recent(host string, now time.Time) []time.Time {
+	var kept []time.Time
+	for _, t := range l.failures[host] {
+		if now.Sub(t) < consoleAuthWindow {
+			kept = append(kept, t)
+		}
+	}
+	if kept == nil {
+		delete(l.failures, host)
+	} else {
+		l.failures[host] = kept
+	}
+
+	return kept
+}
+
+// attempt starts a login from host, reporting false if host has failed too
+// often or has too many logins in progress. Otherwise, the attempt counts as
+// failed from the start, so that concurrent attempts can't get past the
+// limit, and the returned function must be called once it ends to report
+// whether it succeeded.
+func (l *authLimiter) attempt(host string) (func(succeeded bool), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.recent(host, now)) >= consoleAuthFailures || l.pending[host] >= consoleAuthPending {
+		return nil, false
+	}
+	l.failures[host] = append(l.failures[host], now)
+	l.pending[host]++
+
+	return func(succeeded bool) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.pending[host]--; l.pending[host] <= 0 {
+			delete(l.pending, host)
+		}
+		if succeeded {
+			failures := l.failures[host]
+			for i, t := range failures {
+				if t.Equal(now) {
+					l.failures[host] = append(failures[:i:i], failures[i+1:]...)
+					break
+				}
+			}
+			if len(l.failures[host]) == 0 {
+				delete(l.failures, host)
+			}
+		}
+	}, true
+}
+
+// listenConsole listens for TCP connections on the given address, attaching
+// each one that sends the password as its first line to the server console.
+func listenConsole(addr, password string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter := newAuthLimiter()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handleConsoleConn(conn, password, limiter)
+		}
+	}()
+
+	return ln, nil
+}
+
+// handleConsoleConn authenticates a console connection, attaching it to the
+// server console if it sends the password and dropping it otherwise.
+func handleConsoleConn(conn net.Conn, password string, limiter *authLimiter) {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	done, ok := limiter.attempt(host)
+	if !ok {
+		log.Printf("console connection from %s dropped: too many failed or pending logins", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	// The password is read a byte at a time so that input sent right after
+	// it is left for the console.
+	conn.SetDeadline(time.Now().Add(consoleAuthTimeout))
+	io.WriteString(conn, "Password: ")
+	line, err := readLine(io.LimitReader(conn, 1024))
+	if err != nil || subtle.ConstantTimeCompare([]byte(strings.TrimSuffix(line, "\r")), []byte(password)) != 1 {
+		log.Printf("console connection from %s failed to log in", conn.RemoteAddr())
+		time.Sleep(consoleAuthDelay)
+		io.WriteString(conn, "Authentication failed.\n")
+		conn.Close()
+		done(false)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	done(true)
+
+	log.Printf("console connection from %s logged in", conn.RemoteAddr())
+	io.WriteString(conn, "Logged in.\n")
+	serverConsole.attach(conn)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// certPins are the fingerprints that the leaf certificates of servers must
+// match, if any are given with -pin-cert. Pins without a host apply only to
+// downloads, as the wrapper also talks to webhooks and third-party APIs that
+// can't share a certificate.
+var certPins pinFlag
+
+// errPinMismatch is returned when a server's certificate doesn't match the
+// pinned fingerprints. It isn't retried, as the mismatch isn't transient.
+var errPinMismatch = errors.New("certificate doesn't match the pinned fingerprint")
+
+// certPin is the SHA-256 fingerprint of a leaf certificate, required of the
+// given host or, if it is empty, of every download host without pins of its
+// own.
+type certPin struct {
+	Host   string
+	SHA256 string
+}
+
+// pinFlag collects repeated -pin-cert [host=]fingerprint flags.
+type pinFlag []certPin
+
+// String returns the collected pins as a comma separated list.
+func (f *pinFlag) String() string {
+	return strings.Join(f.values(), ",")
+}
+
+// Set parses and adds a pin. The fingerprint is the hex SHA-256 of the
+// certificate, with or without colons between bytes as printed by
+// openssl x509 -fingerprint -sha256.
+func (f *pinFlag) Set(s string) error {
+	host, sum, ok := strings.Cut(s, "=")
+	if !ok {
+		host, sum = "", s
+	}
+
+	sum = strings.ToLower(strings.ReplaceAll(sum, ":", ""))
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("invalid certificate fingerprint %q: must be a SHA-256 in hex", s)
+	}
+
+	*f = append(*f, certPin{Host: strings.ToLower(host), SHA256: sum})
+	return nil
+}
+
+// values returns each pin in [host=]fingerprint form.
+func (f *pinFlag) values() []string {
+	var pins []string
+	for _, pin := range *f {
+		if pin.Host != "" {
+			pins = append(pins, pin.Host+"="+pin.SHA256)
+		} else {
+			pins = append(pins, pin.SHA256)
+		}
+	}
+
+	return pins
+}
+
+// forHost returns the fingerprints required of the given host, including
+// the pins without a host if download is set.
+func (f pinFlag) forHost(host string, download bool) []string {
+	var own, shared []string
+	for _, pin := range f {
+		switch pin.Host {
+		case strings.ToLower(host):
+			own = append(own, pin.SHA256)
+		case "":
+			shared = append(shared, pin.SHA256)
+		}
+	}
+
+	if len(own) > 0 || !download {
+		return own
+	}
+	return shared
+}
+
+// tlsConfig returns the TLS configuration of the HTTP client, for downloads
+// if download is set. Certificates are verified as usual, and must also
+// match the pins if any are given.
+func tlsConfig(download bool) *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(certPins) == 0 {
+		return config
+	}
+
+	pins := certPins
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		want := pins.forHost(cs.ServerName, download)
+		if len(want) == 0 {
+			return nil
+		}
+
+		sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		got := hex.EncodeToString(sum[:])
+		for _, pin := range want {
+			if got == pin {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("%s: %w, the connection may be intercepted by a proxy: got sha256 %s, want %s", cs.ServerName, errPinMismatch, got, strings.Join(want, " or "))
+	}
+
+	return config
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pingTimeout is how long a status ping waits for the server.
+var pingTimeout = 5 * time.Second
+
+// ServerStatus is the status reported by a server in response to a Server List Ping.
+type ServerStatus struct {
+	Version struct {
+		Name     string
+		Protocol int
+	}
+	Players struct {
+		Max    int
+		Online int
+		Sample []struct {
+			Name string
+			ID   string
+		}
+	}
+	Description json.RawMessage
+	MOTD        string `json:"-"`
+}
+
+// chatComponent is a text component used in the server description.
+type chatComponent struct {
+	Text  string
+	Extra []chatComponent
+}
+
+// String returns the plain text of the component and its children.
+func (c chatComponent) String() string {
+	var b strings.Builder
+	b.WriteString(c.Text)
+	for _, extra := range c.Extra {
+		b.WriteString(extra.String())
+	}
+
+	return b.String()
+}
+
+// parseDescription returns the plain text of a description, which is either
+// a string or a chat component.
+func parseDescription(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+
+	var component chatComponent
+	if err := json.Unmarshal(raw, &component); err == nil {
+		return component.String()
+	}
+
+	return string(raw)
+}
+
+// ping queries the status of the server at the given host and port using the
+// modern (1.7+) Server List Ping protocol.
+func ping(host, port string) (ServerStatus, error) {
+	var status ServerStatus
+
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return status, fmt.Errorf("invalid port %q", port)
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), pingTimeout)
+	if err != nil {
+		return status, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(pingTimeout)); err != nil {
+		return status, err
+	}
+
+	// Send a handshake with the next state set to status, then a status request.
+	var handshake bytes.Buffer
+	writeVarInt(&handshake, 0x00)
+	writeVarInt(&handshake, -1)
+	writeVarInt(&handshake, int32(len(host)))
+	handshake.WriteString(host)
+	binary.Write(&handshake, binary.BigEndian, uint16(portNumber))
+	writeVarInt(&handshake, 1)
+
+	var request bytes.Buffer
+	writeVarInt(&request, 0x00)
+
+	for _, packet := range [][]byte{handshake.Bytes(), request.Bytes()} {
+		var frame bytes.Buffer
+		writeVarInt(&frame, int32(len(packet)))
+		frame.Write(packet)
+		if _, err := conn.Write(frame.Bytes()); err != nil {
+			return status, err
+		}
+	}
+
+	// Read the status response, which contains a single JSON string.
+	r := bufio.NewReader(conn)
+	length, err := readVarInt(r)
+	if err != nil {
+		return status, err
+	}
+	if length <= 0 || length > 1<<21 {
+		return status, fmt.Errorf("invalid status packet length %d", length)
+	}
+
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return status, err
+	}
+
+	pr := bytes.NewReader(packet)
+	if id, err := readVarInt(pr); err != nil {
+		return status, err
+	} else if id != 0x00 {
+		return status, fmt.Errorf("unexpected status packet id %d", id)
+	}
+
+	size, err := readVarInt(pr)
+	if err != nil {
+		return status, err
+	}
+	if size < 0 || int(size) > pr.Len() {
+		return status, errors.New("invalid status response")
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(pr, data); err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return status, err
+	}
+	status.MOTD = parseDescription(status.Description)
+
+	return status, nil
+}
+
+// lookupServer returns the host and port to ping for a server given without
+// a port. As in the client, the host's _minecraft._tcp SRV record is
+// followed if it has one, and otherwise the host is used with the fallback
+// port.
+func lookupServer(host, fallback string) (string, string) {
+	if host == "localhost" || net.ParseIP(host) != nil {
+		return host, fallback
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	// Records are returned sorted by priority and randomized by weight.
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "minecraft", "tcp", host)
+	if err != nil || len(records) == 0 {
+		return host, fallback
+	}
+
+	return strings.TrimSuffix(records[0].Target, "."), strconv.Itoa(int(records[0].Port))
+}
+
+// writeVarInt writes a protocol VarInt to the given buffer.
+func writeVarInt(buf *bytes.Buffer, value int32) {
+	v := uint32(value)
+	for {
+		if v&^0x7F == 0 {
+			buf.WriteByte(byte(v))
+			return
+		}
+		buf.WriteByte(byte(v&0x7F | 0x80))
+		v >>= 7
+	}
+}
+
+// readVarInt reads a protocol VarInt from the given reader.
+func readVarInt(r io.ByteReader) (int32, error) {
+	var value uint32
+	for i := 0; i < 5; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint32(b&0x7F) << (7 * i)
+		if b&0x80 == 0 {
+			return int32(value), nil
+		}
+	}
+
+	return 0, errors.New("varint is too long")
+}
+
+// runStatus implements the status subcommand, which reports the status of a running server.
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	host := fs.String("host", "localhost", "Host of the server.")
+	port := fs.String("port", "", "Port of the server. Defaults to the port given by the host's _minecraft._tcp SRV record, or 25565.")
+	fs.DurationVar(&pingTimeout, "timeout", 5*time.Second, "Time to wait for the server to respond.")
+	fs.Parse(args)
+
+	if *port == "" {
+		*host, *port = lookupServer(*host, "25565")
+	}
+	status, err := ping(*host, *port)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("MOTD:    %s\n", status.MOTD)
+	fmt.Printf("Version: %s (protocol %d)\n", status.Version.Name, status.Version.Protocol)
+	fmt.Printf("Players: %d/%d\n", status.Players.Online, status.Players.Max)
+	for _, player := range status.Players.Sample {
+		fmt.Printf("  %s\n", player.Name)
+	}
+
+	return nil
+}
+
+// errUnhealthy is returned by the healthcheck subcommand when the server
+// doesn't respond, and makes the wrapper exit with exitUnhealthy.
+var errUnhealthy = errors.New("server is unhealthy")
+
+// exitUnhealthy is the exit code of a failed health check, which container
+// runtimes such as Docker treat as unhealthy.
+const exitUnhealthy = 1
+
+// runHealthcheck pings the server, succeeding only if it responds in time,
+// for use as a container health check or liveness probe.
+func runHealthcheck(args []string) error {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	host := fs.String("host", "", "Host of the server. Defaults to the server-ip in server.properties in the current directory, or localhost.")
+	port := fs.String("port", "", "Port of the server. Defaults to the port given by the host's _minecraft._tcp SRV record, or the server-port in server.properties in the current directory, or 25565.")
+	fs.DurationVar(&pingTimeout, "timeout", 3*time.Second, "Time to wait for the server to respond.")
+	fs.Parse(args)
+
+	if *host == "" {
+		*host = serverHost("")
+	}
+	if *port == "" {
+		*host, *port = lookupServer(*host, serverPort(""))
+	}
+	status, err := ping(*host, *port)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnhealthy, err)
+	}
+
+	fmt.Printf("healthy: %s, %d/%d players\n", status.Version.Name, status.Players.Online, status.Players.Max)
+	return nil
+}
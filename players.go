@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profileURL is the Mojang API endpoint that resolves a username to a profile.
+var profileURL = "https://api.mojang.com/users/profiles/minecraft/"
+
+// errUnknownPlayer is returned when no player has the given username.
+var errUnknownPlayer = errors.New("unknown player")
+
+// uuidCacheTTL is how long a cached username lookup is used, as usernames
+// can change hands.
+const uuidCacheTTL = 24 * time.Hour
+
+// uuidCacheEntry is a cached username lookup.
+type uuidCacheEntry struct {
+	UUID    string    `json:"uuid"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// uuidCacheFile returns the file username lookups are cached in, or an
+// empty string if there is no user cache directory.
+func uuidCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "minecraft-server", "uuids.json")
+}
+
+// usernameToUUID returns the hyphenated UUID of the player with the given
+// username. Lookups are cached to respect Mojang's rate limits.
+func usernameToUUID(ctx context.Context, name string) (string, error) {
+	key := strings.ToLower(name)
+	cacheFile := uuidCacheFile()
+	cache := make(map[string]uuidCacheEntry)
+	if cacheFile != "" {
+		if data, err := os.ReadFile(cacheFile); err == nil {
+			if err := json.Unmarshal(data, &cache); err != nil {
+				log.Printf("warning: ignoring invalid uuid cache %s: %v", cacheFile, err)
+				cache = make(map[string]uuidCacheEntry)
+			}
+		}
+		if entry, ok := cache[key]; ok && time.Since(entry.Fetched) < uuidCacheTTL {
+			return entry.UUID, nil
+		}
+	}
+
+	uuid, err := lookupUUID(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	if cacheFile != "" {
+		cache[key] = uuidCacheEntry{UUID: uuid, Fetched: time.Now()}
+		if err := writeJSONFile(cacheFile, cache); err != nil {
+			log.Printf("warning: failed to cache uuid: %v", err)
+		}
+	}
+
+	return uuid, nil
+}
+
+// writeJSONFile writes v as JSON to the given file, creating its directory.
+func writeJSONFile(filename string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
+}
+
+// lookupUUID looks up the hyphenated UUID of the player with the given
+// username using the Mojang API.
+func lookupUUID(ctx context.Context, name string) (string, error) {
+	var profile struct {
+		ID   string
+		Name string
+	}
+
+	// Mojang replies with no content, or not found, for unknown usernames.
+	err := getJSON(ctx, profileURL+url.PathEscape(name), &profile)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNoContent || statusErr.StatusCode == http.StatusNotFound) {
+		return "", fmt.Errorf("%w %q", errUnknownPlayer, name)
+	} else if err != nil {
+		return "", err
+	}
+
+	return hyphenateUUID(profile.ID)
+}
+
+// hyphenateUUID formats a UUID given as 32 hex digits in its hyphenated form.
+func hyphenateUUID(id string) (string, error) {
+	id = strings.ToLower(strings.ReplaceAll(id, "-", ""))
+	if len(id) != 32 {
+		return "", fmt.Errorf("invalid uuid %q", id)
+	}
+
+	return id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:], nil
+}
+
+// whitelistEntry is a player in whitelist.json.
+type whitelistEntry struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+// loadPlayerList reads a JSON list of players, such as whitelist.json, into
+// target. A missing file leaves target unchanged.
+func loadPlayerList(filename string, target interface{}) error {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return nil
+}
+
+// savePlayerList writes a JSON list of players in the server's format.
+func savePlayerList(filename string, list interface{}) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// reloadOverRCON sends the given command to the server over RCON if it is
+// configured, so that changes to its files take effect without a restart.
+// A server that isn't running is not an error, as it reads the files when
+// it starts.
+func reloadOverRCON(command string) {
+	if !rconConfigured() {
+		return
+	}
+
+	client, err := dialServerRCON()
+	if err != nil {
+		log.Printf("couldn't reach the server over rcon, changes take effect when it next starts: %v", err)
+		return
+	}
+	defer client.Close()
+
+	if _, err := client.command(command); err != nil {
+		log.Printf("failed to run %q over rcon: %v", command, err)
+	}
+}
+
+// runWhitelist adds, removes, or lists the players in whitelist.json.
+func runWhitelist(args []string) error {
+	fs := flag.NewFlagSet("whitelist", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory of the server.")
+	fs.StringVar(&rconHost, "rcon-host", "localhost", "Host of the server's RCON interface.")
+	fs.StringVar(&rconPort, "rcon-port", "25575", "Port of the server's RCON interface.")
+	fs.StringVar(&rconPassword, "rcon-password", "", "RCON password of the server, used to reload the whitelist if it's running. Defaults to MINECRAFT_RCON_PASSWORD.")
+	fs.DurationVar(&httpTimeout, "http-timeout", httpTimeout, "Timeout for HTTP requests.")
+	addProxyFlags(fs)
+	fs.Parse(args)
+	if err := applySecretEnv(fs); err != nil {
+		return err
+	}
+
+	usage := errors.New("usage: whitelist [flags] add|remove <player> | list")
+	if fs.NArg() == 0 {
+		return usage
+	}
+	httpClient = newHTTPClient(httpTimeout)
+
+	filename := filepath.Join(*dir, "whitelist.json")
+	whitelist := []whitelistEntry{}
+	if err := loadPlayerList(filename, &whitelist); err != nil {
+		return err
+	}
+
+	switch action := fs.Arg(0); {
+	case action == "list" && fs.NArg() == 1:
+		for _, entry := range whitelist {
+			fmt.Println(entry.Name)
+		}
+		return nil
+
+	case action == "add" && fs.NArg() == 2:
+		name := fs.Arg(1)
+		for _, entry := range whitelist {
+			if strings.EqualFold(entry.Name, name) {
+				return fmt.Errorf("%s is already whitelisted", entry.Name)
+			}
+		}
+
+		uuid, err := usernameToUUID(context.Background(), name)
+		if err != nil {
+			return err
+		}
+		whitelist = append(whitelist, whitelistEntry{UUID: uuid, Name: name})
+		log.Printf("added %s (%s) to the whitelist", name, uuid)
+
+	case action == "remove" && fs.NArg() == 2:
+		name := fs.Arg(1)
+		i := 0
+		for _, entry := range whitelist {
+			if !strings.EqualFold(entry.Name, name) {
+				whitelist[i] = entry
+				i++
+			}
+		}
+		if i == len(whitelist) {
+			return fmt.Errorf("%s isn't whitelisted", name)
+		}
+		whitelist = whitelist[:i]
+		log.Printf("removed %s from the whitelist", name)
+
+	default:
+		return usage
+	}
+
+	if err := savePlayerList(filename, whitelist); err != nil {
+		return err
+	}
+
+	reloadOverRCON("whitelist reload")
+	return nil
+}
+
+// runUUID prints the UUID of the player with the given username.
+func runUUID(args []string) error {
+	fs := flag.NewFlagSet("uuid", flag.ExitOnError)
+	fs.DurationVar(&httpTimeout, "http-timeout", httpTimeout, "Timeout for HTTP requests.")
+	addProxyFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: uuid [flags] <player>")
+	}
+	httpClient = newHTTPClient(httpTimeout)
+
+	uuid, err := usernameToUUID(context.Background(), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(uuid)
+	return nil
+}
+
+// runOp adds, removes, or lists the operators in ops.json. Entries are kept
+// as generic objects so that fields the wrapper doesn't know are preserved.
+func runOp(args []string) error {
+	fs := flag.NewFlagSet("op", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory of the server.")
+	fs.StringVar(&rconHost, "rcon-host", "localhost", "Host of the server's RCON interface.")
+	fs.StringVar(&rconPort, "rcon-port", "25575", "Port of the server's RCON interface.")
+	fs.StringVar(&rconPassword, "rcon-password", "", "RCON password of the server, used to apply the change if it's running. Defaults to MINECRAFT_RCON_PASSWORD.")
+	fs.DurationVar(&httpTimeout, "http-timeout", httpTimeout, "Timeout for HTTP requests.")
+	addProxyFlags(fs)
+	fs.Parse(args)
+	if err := applySecretEnv(fs); err != nil {
+		return err
+	}
+
+	usage := errors.New("usage: op [flags] add <player> [level] | remove <player> | list")
+	if fs.NArg() == 0 {
+		return usage
+	}
+	httpClient = newHTTPClient(httpTimeout)
+
+	filename := filepath.Join(*dir, "ops.json")
+	ops := []map[string]interface{}{}
+	if err := loadPlayerList(filename, &ops); err != nil {
+		return err
+	}
+
+	// opName returns the name of an entry, or an empty string if it has none.
+	opName := func(op map[string]interface{}) string {
+		name, _ := op["name"].(string)
+		return name
+	}
+
+	var command string
+	switch action := fs.Arg(0); {
+	case action == "list" && fs.NArg() == 1:
+		for _, op := range ops {
+			fmt.Printf("%s (level %v)\n", opName(op), op["level"])
+		}
+		return nil
+
+	case action == "add" && (fs.NArg() == 2 || fs.NArg() == 3):
+		name := fs.Arg(1)
+		level := 4
+		if fs.NArg() == 3 {
+			n, err := strconv.Atoi(fs.Arg(2))
+			if err != nil || n < 1 || n > 4 {
+				return fmt.Errorf("invalid op level %q: must be 1 to 4", fs.Arg(2))
+			}
+			level = n
+		}
+
+		uuid, err := usernameToUUID(context.Background(), name)
+		if err != nil {
+			return err
+		}
+
+		// Update an existing entry in place, keeping its other fields.
+		var entry map[string]interface{}
+		for _, op := range ops {
+			if strings.EqualFold(opName(op), name) {
+				entry = op
+			}
+		}
+		if entry == nil {
+			entry = map[string]interface{}{"bypassesPlayerLimit": false}
+			ops = append(ops, entry)
+		}
+		entry["uuid"] = uuid
+		entry["name"] = name
+		entry["level"] = level
+		log.Printf("made %s (%s) an operator with level %d", name, uuid, level)
+
+		// A running server opped over RCON writes ops.json itself with its
+		// op-permission-level, replacing the level written here, so other
+		// levels are left to be read from ops.json when it restarts.
+		if level == opPermissionLevel(*dir) {
+			command = "op " + name
+		} else if rconConfigured() {
+			log.Printf("level %d differs from the server's op-permission-level, so %s isn't opped over rcon; restart the server to apply it", level, name)
+		}
+
+	case action == "remove" && fs.NArg() == 2:
+		name := fs.Arg(1)
+		i := 0
+		for _, op := range ops {
+			if !strings.EqualFold(opName(op), name) {
+				ops[i] = op
+				i++
+			}
+		}
+		if i == len(ops) {
+			return fmt.Errorf("%s isn't an operator", name)
+		}
+		ops = ops[:i]
+		log.Printf("removed %s from the operators", name)
+		command = "deop " + name
+
+	default:
+		return usage
+	}
+
+	if err := savePlayerList(filename, ops); err != nil {
+		return err
+	}
+
+	if command != "" {
+		reloadOverRCON(command)
+	}
+	return nil
+}
+
+// opPermissionLevel returns the op-permission-level that the server in the
+// given directory gives players opped while it runs.
+func opPermissionLevel(dir string) int {
+	if p, err := loadProperties(filepath.Join(dir, "server.properties")); err == nil {
+		if value, ok := p.get("op-permission-level"); ok {
+			if level, err := strconv.Atoi(value); err == nil {
+				return level
+			}
+		}
+	}
+
+	return 4
+}
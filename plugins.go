@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// plugin is a plugin listed in a -plugins file.
+type plugin struct {
+	URL  string
+	SHA1 string
+}
+
+// filename returns the name the plugin is saved under, taken from its URL.
+func (p plugin) filename() (string, error) {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return "", err
+	}
+
+	name := path.Base(u.Path)
+	if name == "." || name == "/" || !strings.HasSuffix(name, ".jar") {
+		return "", fmt.Errorf("can't tell the plugin filename from %s; its path must end in a .jar file", p.URL)
+	}
+
+	return name, nil
+}
+
+// loadPlugins reads a plugins file, which lists a plugin download URL and
+// optionally its SHA1 on each line. Blank lines and lines starting with #
+// are ignored.
+func loadPlugins(filename string) ([]plugin, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var plugins []plugin
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected a URL and an optional sha1", filename, n)
+		}
+
+		p := plugin{URL: fields[0]}
+		if len(fields) == 2 {
+			p.SHA1 = strings.ToLower(fields[1])
+		}
+		plugins = append(plugins, p)
+	}
+
+	return plugins, scanner.Err()
+}
+
+// installPlugins downloads the plugins listed in the given file into the
+// plugins directory, skipping those already present with a matching
+// checksum, or present at all if they have no checksum.
+func installPlugins(ctx context.Context, filename, pluginDir string) error {
+	plugins, err := loadPlugins(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(pluginDir, 0755); err != nil {
+		return err
+	}
+
+	var downloaded, skipped int
+	for _, p := range plugins {
+		name, err := p.filename()
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(pluginDir, name)
+
+		if _, err := os.Stat(dest); err == nil && (p.SHA1 == "" || verifySHA1(dest, p.SHA1) == nil) {
+			log.Printf("plugin %s is up to date, skipping", name)
+			skipped++
+			continue
+		}
+
+		if p.SHA1 == "" {
+			log.Printf("warning: no checksum given for plugin %s, skipping verification", name)
+		}
+		log.Printf("downloading plugin %s", name)
+		if err := downloadFile(ctx, dest, p.URL, "sha1", p.SHA1, true); err != nil {
+			return fmt.Errorf("plugin %s: %w", name, err)
+		}
+		downloaded++
+	}
+
+	log.Printf("plugins: %d downloaded, %d skipped", downloaded, skipped)
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// configureProcess is a no-op on platforms without process groups.
+func configureProcess(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the server, as it has no process group of its own
+// on this platform.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// setRunAs returns an error if a user or group is given, as running the
+// server as another user isn't supported on this platform.
+func setRunAs(userName, groupName string) error {
+	if userName != "" || groupName != "" {
+		return errors.New("-run-as-user and -run-as-group aren't supported on this platform")
+	}
+
+	return nil
+}
+
+// chownToRunAs is a no-op on platforms where the server can't run as another user.
+func chownToRunAs(dir, jar string, files ...string) error {
+	return nil
+}
@@ -0,0 +1,175 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// credential is the user and group the server runs as, or nil to run it as
+// the wrapper's own user.
+var credential *syscall.Credential
+
+// configureProcess places the server in its own process group so terminal
+// signals reach the wrapper only, letting it stop the server gracefully. It
+// also runs the server as the -run-as-user and -run-as-group, if set.
+func configureProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: credential}
+}
+
+// killProcessGroup kills the server's process group, which also kills any
+// processes the server started.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// setRunAs resolves the user and group, given as names or IDs, that the
+// server is run as. Either may be empty; the group defaults to the user's
+// primary group, and the user to the wrapper's own.
+func setRunAs(userName, groupName string) error {
+	if userName == "" && groupName == "" {
+		return nil
+	}
+
+	cred := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid()), NoSetGroups: true}
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			if u, err = user.LookupId(userName); err != nil {
+				return fmt.Errorf("unknown user %q", userName)
+			}
+		}
+
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("user %q has a non-numeric uid %q", userName, u.Uid)
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("user %q has a non-numeric gid %q", userName, u.Gid)
+		}
+		cred.Uid, cred.Gid = uint32(uid), uint32(gid)
+
+		// Give the server the user's supplementary groups rather than the
+		// wrapper's, which as root's could grant it far more access.
+		ids, err := u.GroupIds()
+		if err != nil {
+			return fmt.Errorf("looking up the groups of user %q: %w", userName, err)
+		}
+		cred.NoSetGroups = false
+		for _, id := range ids {
+			n, err := strconv.ParseUint(id, 10, 32)
+			if err != nil {
+				return fmt.Errorf("user %q has a non-numeric group id %q", userName, id)
+			}
+			cred.Groups = append(cred.Groups, uint32(n))
+		}
+	}
+
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return fmt.Errorf("unknown group %q", groupName)
+			}
+		}
+
+		gid, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("group %q has a non-numeric gid %q", groupName, g.Gid)
+		}
+		cred.Gid = uint32(gid)
+	}
+
+	// Only root may run processes as another user or group.
+	if os.Geteuid() != 0 && (cred.Uid != uint32(os.Geteuid()) || cred.Gid != uint32(os.Getegid())) {
+		return fmt.Errorf("-run-as-user and -run-as-group require running as root, but the wrapper runs as uid %d", os.Geteuid())
+	}
+
+	credential = cred
+	return nil
+}
+
+// serverFiles are the files and directories in a server's directory that
+// the wrapper may create for it, besides its worlds.
+var serverFiles = []string{
+	"eula.txt", "server.properties", "ops.json", "whitelist.json",
+	"allowlist.json", "permissions.json", "plugins", "logs", "worlds",
+}
+
+// chownToRunAs gives the server's directory, the files the wrapper created
+// for the server in it, the server's jar and its checksum, and the other
+// given files to the user and group the server runs as, so that it can
+// write to them. Only the world, plugins, and logs directories are given
+// with everything under them. Empty and missing paths are skipped. It does
+// nothing unless -run-as-user or -run-as-group is set.
+func chownToRunAs(dir, jar string, files ...string) error {
+	if credential == nil {
+		return nil
+	}
+	if err := checkDedicatedDir(dir); err != nil {
+		return err
+	}
+
+	uid, gid := int(credential.Uid), int(credential.Gid)
+	chown := func(path string) error {
+		if err := os.Lchown(path, uid, gid); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("giving files to the server's user: %w", err)
+		}
+		return nil
+	}
+
+	if err := chown(dir); err != nil {
+		return err
+	}
+	if jar != "" {
+		files = append(files, jar, jar+".sha1")
+	}
+	for _, file := range files {
+		if file != "" {
+			if err := chown(file); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range append(worldDirs(dir), serverFiles...) {
+		err := filepath.WalkDir(filepath.Join(dir, name), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(path, uid, gid)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("giving files to the server's user: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// checkDedicatedDir returns an error if the given server directory isn't
+// one dedicated to the server, such as the root directory, a top-level
+// directory like /opt, or the wrapper's home directory, which mustn't be
+// given to the server's user.
+func checkDedicatedDir(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	parent := filepath.Dir(abs)
+	home, _ := os.UserHomeDir()
+	if parent == abs || filepath.Dir(parent) == parent || (home != "" && abs == filepath.Clean(home)) {
+		return fmt.Errorf("-run-as-user requires a directory dedicated to the server, not %s, as it is given to the server's user", abs)
+	}
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultProperties are the values written to a newly initialized server.properties.
+var defaultProperties = [][2]string{
+	{"motd", "A Minecraft Server"},
+	{"max-players", "20"},
+	{"online-mode", "true"},
+	{"server-port", "25565"},
+}
+
+// properties is a Java properties file. Its lines are kept as is so that
+// comments and key order survive being rewritten.
+type properties struct {
+	lines []string
+}
+
+// loadProperties reads the properties file with the given filename,
+// returning empty properties if it doesn't exist.
+func loadProperties(filename string) (*properties, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return &properties{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	p := &properties{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		p.lines = append(p.lines, scanner.Text())
+	}
+
+	return p, scanner.Err()
+}
+
+// parsePropertyLine returns the key and value of a line, or false if the
+// line is blank or a comment.
+func parsePropertyLine(line string) (string, string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "!") {
+		return "", "", false
+	}
+
+	key, value, _ := strings.Cut(trimmed, "=")
+	return strings.TrimSpace(key), strings.TrimSpace(value), true
+}
+
+// get returns the value of the given key and whether it is present.
+func (p *properties) get(key string) (string, bool) {
+	for _, line := range p.lines {
+		if k, v, ok := parsePropertyLine(line); ok && k == key {
+			return v, true
+		}
+	}
+
+	return "", false
+}
+
+// set sets the value of the given key, rewriting its line if present and
+// appending one otherwise.
+func (p *properties) set(key, value string) {
+	for i, line := range p.lines {
+		if k, _, ok := parsePropertyLine(line); ok && k == key {
+			p.lines[i] = key + "=" + value
+			return
+		}
+	}
+
+	p.lines = append(p.lines, key+"="+value)
+}
+
+// unset removes the lines setting the given key.
+func (p *properties) unset(key string) {
+	lines := p.lines[:0]
+	for _, line := range p.lines {
+		if k, _, ok := parsePropertyLine(line); !ok || k != key {
+			lines = append(lines, line)
+		}
+	}
+
+	p.lines = lines
+}
+
+// save writes the properties to the file with the given filename.
+func (p *properties) save(filename string) error {
+	var buf bytes.Buffer
+	for _, line := range p.lines {
+		buf.WriteString(line + "\n")
+	}
+
+	return os.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// validatePort returns an error if the given port is out of range.
+func validatePort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %d, must be between 1 and 65535", port)
+	}
+
+	return nil
+}
+
+// setServerPort writes the given port to the properties file with the given
+// filename, also setting the query port if query is enabled.
+func setServerPort(filename string, port int) error {
+	if err := validatePort(port); err != nil {
+		return err
+	}
+
+	p, err := loadProperties(filename)
+	if err != nil {
+		return err
+	}
+
+	p.set("server-port", strconv.Itoa(port))
+	if query, _ := p.get("enable-query"); query == "true" {
+		p.set("query.port", strconv.Itoa(port))
+	}
+
+	return p.save(filename)
+}
+
+// serverPort returns the port from server.properties in the given
+// directory, or the default port if it isn't set.
+func serverPort(dir string) string {
+	if p, err := loadProperties(filepath.Join(dir, "server.properties")); err == nil {
+		if port, ok := p.get("server-port"); ok && port != "" {
+			return port
+		}
+	}
+
+	return "25565"
+}
+
+// serverHost returns the address to reach the server in the given directory
+// at, which is the server-ip in its server.properties if it binds a single
+// address, or localhost.
+func serverHost(dir string) string {
+	if p, err := loadProperties(filepath.Join(dir, "server.properties")); err == nil {
+		value, _ := p.get("server-ip")
+		if ip := net.ParseIP(value); ip != nil && !ip.IsUnspecified() {
+			return ip.String()
+		}
+	}
+
+	return "localhost"
+}
+
+// parseBindAddress returns the IP address given to -bind without any
+// brackets around an IPv6 address, or an empty string for all interfaces.
+func parseBindAddress(addr string) (string, error) {
+	if addr == "" {
+		return "", nil
+	}
+
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+	if ip == nil {
+		return "", fmt.Errorf("invalid bind address %q, must be an IPv4 or IPv6 address", addr)
+	}
+
+	return ip.String(), nil
+}
+
+// checkPortFree returns an error if the server port configured in the
+// given properties file is already in use, such as by another server or a
+// java process left behind by a previous run. It listens on the port briefly
+// so the problem is reported before the server spends time starting up.
+func checkPortFree(filename string) error {
+	addr, err := serverAddr(filename)
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		_, port, _ := net.SplitHostPort(addr)
+		return fmt.Errorf("port %s already in use, set a different server-port in %s or stop the process using it: %w", port, filename, err)
+	}
+
+	return ln.Close()
+}
+
+// serverAddr returns the address the server listens on, from the
+// server-ip and server-port in the given properties file.
+func serverAddr(filename string) (string, error) {
+	p, err := loadProperties(filename)
+	if err != nil {
+		return "", err
+	}
+
+	port, ok := p.get("server-port")
+	if !ok || port == "" {
+		port = "25565"
+	}
+	ip, _ := p.get("server-ip")
+
+	return net.JoinHostPort(ip, port), nil
+}
+
+// keyValueFlag collects repeated key=value flags in order.
+type keyValueFlag [][2]string
+
+// String returns the collected pairs as a comma separated list.
+func (f *keyValueFlag) String() string {
+	var pairs []string
+	for _, kv := range *f {
+		pairs = append(pairs, kv[0]+"="+kv[1])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// Set parses and adds a key=value pair.
+func (f *keyValueFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("%q is not in key=value form", s)
+	}
+	*f = append(*f, [2]string{key, value})
+
+	return nil
+}
+
+// updateServerProperties adds any missing default properties to the given
+// file if init is set, then applies the given overrides.
+func updateServerProperties(filename string, init bool, overrides [][2]string) error {
+	p, err := loadProperties(filename)
+	if err != nil {
+		return err
+	}
+
+	// Only add defaults that are missing so existing settings are kept.
+	if init {
+		for _, kv := range defaultProperties {
+			if _, ok := p.get(kv[0]); !ok {
+				p.set(kv[0], kv[1])
+			}
+		}
+	}
+
+	for _, kv := range overrides {
+		p.set(kv[0], kv[1])
+	}
+
+	return p.save(filename)
+}
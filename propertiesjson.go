@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// propertyTypes gives the type of the keys the server knows, which are
+// exported as JSON booleans and numbers and validated on import. Keys of
+// other types are strings.
+var propertyTypes = map[string]string{
+	"accepts-transfers":                 "bool",
+	"allow-flight":                      "bool",
+	"allow-nether":                      "bool",
+	"broadcast-console-to-ops":          "bool",
+	"broadcast-rcon-to-ops":             "bool",
+	"bug-report-link":                   "string",
+	"difficulty":                        "string",
+	"enable-command-block":              "bool",
+	"enable-jmx-monitoring":             "bool",
+	"enable-query":                      "bool",
+	"enable-rcon":                       "bool",
+	"enable-status":                     "bool",
+	"enforce-secure-profile":            "bool",
+	"enforce-whitelist":                 "bool",
+	"entity-broadcast-range-percentage": "int",
+	"force-gamemode":                    "bool",
+	"function-permission-level":         "int",
+	"gamemode":                          "string",
+	"generate-structures":               "bool",
+	"generator-settings":                "string",
+	"hardcore":                          "bool",
+	"hide-online-players":               "bool",
+	"initial-disabled-packs":            "string",
+	"initial-enabled-packs":             "string",
+	"level-name":                        "string",
+	"level-seed":                        "string",
+	"level-type":                        "string",
+	"log-ips":                           "bool",
+	"max-chained-neighbor-updates":      "int",
+	"max-players":                       "int",
+	"max-tick-time":                     "int",
+	"max-world-size":                    "int",
+	"motd":                              "string",
+	"network-compression-threshold":     "int",
+	"online-mode":                       "bool",
+	"op-permission-level":               "int",
+	"pause-when-empty-seconds":          "int",
+	"player-idle-timeout":               "int",
+	"prevent-proxy-connections":         "bool",
+	"pvp":                               "bool",
+	"query.port":                        "int",
+	"rate-limit":                        "int",
+	"rcon.password":                     "string",
+	"rcon.port":                         "int",
+	"region-file-compression":           "string",
+	"require-resource-pack":             "bool",
+	"resource-pack":                     "string",
+	"resource-pack-id":                  "string",
+	"resource-pack-prompt":              "string",
+	"resource-pack-sha1":                "string",
+	"server-ip":                         "string",
+	"server-port":                       "int",
+	"simulation-distance":               "int",
+	"spawn-animals":                     "bool",
+	"spawn-monsters":                    "bool",
+	"spawn-npcs":                        "bool",
+	"spawn-protection":                  "int",
+	"sync-chunk-writes":                 "bool",
+	"text-filtering-config":             "string",
+	"text-filtering-version":            "int",
+	"use-native-transport":              "bool",
+	"view-distance":                     "int",
+	"white-list":                        "bool",
+}
+
+// exportProperties writes the properties as a JSON object with its keys in
+// file order, giving the values of known boolean and integer keys as JSON
+// booleans and numbers.
+func exportProperties(w io.Writer, p *properties) error {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	first := true
+	for _, line := range p.lines {
+		key, value, ok := parsePropertyLine(line)
+		if !ok {
+			continue
+		}
+
+		var typed interface{} = value
+		switch propertyTypes[key] {
+		case "bool":
+			if b, err := strconv.ParseBool(value); err == nil {
+				typed = b
+			}
+		case "int":
+			if n, err := strconv.Atoi(value); err == nil {
+				typed = n
+			}
+		}
+
+		k, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(typed)
+		if err != nil {
+			return err
+		}
+		if !first {
+			buf.WriteString(",")
+		}
+		first = false
+		fmt.Fprintf(&buf, "\n  %s: %s", k, v)
+	}
+	if !first {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// decodePropertiesJSON reads a JSON object of properties, returning its
+// key and value pairs in order. Values may be strings, booleans, or
+// numbers, and must have the right type for known keys. Unknown keys are
+// logged, as plugins and newer versions may add their own.
+func decodePropertiesJSON(r io.Reader) ([][2]string, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, errors.New("properties must be a JSON object")
+	}
+
+	var pairs [][2]string
+	seen := make(map[string]bool)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+		seen[key] = true
+
+		var raw interface{}
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+
+		var value string
+		switch v := raw.(type) {
+		case string:
+			value = v
+		case bool:
+			value = strconv.FormatBool(v)
+		case json.Number:
+			value = v.String()
+		default:
+			return nil, fmt.Errorf("%s: must be a string, boolean, or number", key)
+		}
+
+		switch typ, known := propertyTypes[key]; {
+		case !known:
+			log.Printf("warning: unknown property %q", key)
+		case typ == "bool":
+			if _, err := strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("%s: %q is not a boolean", key, value)
+			}
+		case typ == "int":
+			if _, err := strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("%s: %q is not an integer", key, value)
+			}
+		}
+
+		pairs = append(pairs, [2]string{key, value})
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return pairs, nil
+}
+
+// importProperties replaces the properties with the given pairs. Lines of
+// keys that are kept are rewritten in place so that comments and key order
+// survive, keys that aren't given are removed, and new keys are appended in
+// order.
+func importProperties(p *properties, pairs [][2]string) {
+	keep := make(map[string]bool)
+	for _, kv := range pairs {
+		keep[kv[0]] = true
+	}
+
+	lines := p.lines[:0]
+	for _, line := range p.lines {
+		if key, _, ok := parsePropertyLine(line); !ok || keep[key] {
+			lines = append(lines, line)
+		}
+	}
+	p.lines = lines
+
+	for _, kv := range pairs {
+		if value, ok := p.get(kv[0]); !ok || value != kv[1] {
+			p.set(kv[0], kv[1])
+		}
+	}
+}
+
+// runProperties converts server.properties to and from JSON.
+func runProperties(args []string) error {
+	fs := flag.NewFlagSet("properties", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory of the server.")
+	fs.Parse(args)
+
+	usage := errors.New("usage: properties [flags] export | import [file.json]")
+	filename := filepath.Join(*dir, "server.properties")
+	switch action := fs.Arg(0); {
+	case action == "export" && fs.NArg() == 1:
+		if _, err := os.Stat(filename); err != nil {
+			return err
+		}
+		p, err := loadProperties(filename)
+		if err != nil {
+			return err
+		}
+		return exportProperties(os.Stdout, p)
+
+	case action == "import" && fs.NArg() <= 2:
+		// The JSON is read from stdin if no file is given.
+		r, name := io.Reader(os.Stdin), "stdin"
+		if fs.NArg() == 2 && fs.Arg(1) != "-" {
+			f, err := os.Open(fs.Arg(1))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r, name = f, fs.Arg(1)
+		}
+
+		pairs, err := decodePropertiesJSON(r)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		p, err := loadProperties(filename)
+		if err != nil {
+			return err
+		}
+		importProperties(p, pairs)
+		if err := p.save(filename); err != nil {
+			return err
+		}
+
+		log.Printf("wrote %d properties to %s", len(pairs), filename)
+		return nil
+
+	default:
+		return usage
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// downloadRateLimit caps download speed in bytes per second, or is zero for no limit.
+var downloadRateLimit int64
+
+// setDownloadRateLimit sets the download rate limit from a size such as 2M.
+func setDownloadRateLimit(s string) error {
+	rate, err := parseByteSize(s)
+	if err != nil {
+		return fmt.Errorf("invalid download rate limit: %w", err)
+	}
+
+	downloadRateLimit = rate
+	return nil
+}
+
+// rateLimitedReader limits reads from r to a rate in bytes per second using
+// a token bucket that holds up to a second's worth of bytes.
+type rateLimitedReader struct {
+	ctx    context.Context
+	r      io.Reader
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimitedReader returns a reader that reads from r at no more than
+// rate bytes per second, giving up waiting once ctx is done.
+func newRateLimitedReader(ctx context.Context, r io.Reader, rate int64) *rateLimitedReader {
+	return &rateLimitedReader{ctx: ctx, r: r, rate: float64(rate), last: time.Now()}
+}
+
+// Read reads at most a bucket's worth of bytes, then waits until the bucket
+// has refilled enough to pay for them.
+func (l *rateLimitedReader) Read(b []byte) (int, error) {
+	if burst := int(l.rate); len(b) > burst {
+		b = b[:max(burst, 1)]
+	}
+
+	n, err := l.r.Read(b)
+
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate) - float64(n)
+	l.last = now
+
+	if l.tokens < 0 {
+		wait := time.NewTimer(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+		defer wait.Stop()
+		select {
+		case <-wait.C:
+		case <-l.ctx.Done():
+			return n, l.ctx.Err()
+		}
+	}
+
+	return n, err
+}
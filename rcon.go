@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// RCON packet types used by the Source RCON protocol.
+const (
+	rconTypeResponse = 0
+	rconTypeCommand  = 2
+	rconTypeLogin    = 3
+)
+
+// rconMaxPacketSize is the largest packet accepted from the server.
+const rconMaxPacketSize = 4096 + 14
+
+// rconPacket is a single packet of the Source RCON protocol.
+type rconPacket struct {
+	ID   int32
+	Type int32
+	Body string
+}
+
+// encodeRCONPacket encodes a packet as a length prefixed little endian frame.
+func encodeRCONPacket(p rconPacket) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, int32(len(p.Body)+10))
+	binary.Write(&buf, binary.LittleEndian, p.ID)
+	binary.Write(&buf, binary.LittleEndian, p.Type)
+	buf.WriteString(p.Body)
+	buf.Write([]byte{0, 0})
+
+	return buf.Bytes()
+}
+
+// decodeRCONPacket reads a single packet from the given reader.
+func decodeRCONPacket(r io.Reader) (rconPacket, error) {
+	var length int32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return rconPacket{}, err
+	}
+	if length < 10 || length > rconMaxPacketSize {
+		return rconPacket{}, fmt.Errorf("invalid rcon packet length %d", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return rconPacket{}, err
+	}
+
+	// The body is followed by two null bytes.
+	return rconPacket{
+		ID:   int32(binary.LittleEndian.Uint32(data[0:4])),
+		Type: int32(binary.LittleEndian.Uint32(data[4:8])),
+		Body: string(data[8 : length-2]),
+	}, nil
+}
+
+// rconConfigured reports whether the wrapper has been given the RCON password of the server.
+func rconConfigured() bool {
+	return rconPassword != ""
+}
+
+// dialServerRCON connects to the RCON interface of the wrapped server.
+func dialServerRCON() (*rconClient, error) {
+	return dialRCON(net.JoinHostPort(rconHost, rconPort), rconPassword, 5*time.Second)
+}
+
+// rconConfigured reports whether the RCON password of the server is known.
+func (s *server) rconConfigured() bool {
+	return s.rconPassword != ""
+}
+
+// dialRCON connects to the RCON interface of the server.
+func (s *server) dialRCON() (*rconClient, error) {
+	return dialRCON(s.rconAddr, s.rconPassword, 5*time.Second)
+}
+
+// rconClient is an authenticated connection to a server's RCON interface.
+type rconClient struct {
+	conn    net.Conn
+	timeout time.Duration
+	nextID  int32
+}
+
+// dialRCON connects to the RCON interface at the given address and logs in with the given password.
+func dialRCON(addr, password string, timeout time.Duration) (*rconClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &rconClient{conn: conn, timeout: timeout}
+	id, err := c.send(rconTypeLogin, password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The server replies with an id of -1 if the password is wrong.
+	resp, err := c.receive()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.ID == -1 || resp.ID != id {
+		conn.Close()
+		return nil, errors.New("rcon authentication failed")
+	}
+
+	return c, nil
+}
+
+// command runs the given command and returns its response, joining responses split over multiple packets.
+func (c *rconClient) command(command string) (string, error) {
+	id, err := c.send(rconTypeCommand, command)
+	if err != nil {
+		return "", err
+	}
+
+	// Follow the command with an empty response packet; its reply marks the end of the command's response.
+	end, err := c.send(rconTypeResponse, "")
+	if err != nil {
+		return "", err
+	}
+
+	var body strings.Builder
+	for {
+		resp, err := c.receive()
+		if err != nil {
+			return "", err
+		}
+		if resp.ID == end {
+			return body.String(), nil
+		}
+		if resp.ID == id {
+			body.WriteString(resp.Body)
+		}
+	}
+}
+
+// Close closes the connection to the server.
+func (c *rconClient) Close() error {
+	return c.conn.Close()
+}
+
+// send writes a packet with a new id and returns the id.
+func (c *rconClient) send(typ int32, body string) (int32, error) {
+	c.nextID++
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	_, err := c.conn.Write(encodeRCONPacket(rconPacket{ID: c.nextID, Type: typ, Body: body}))
+	return c.nextID, err
+}
+
+// receive reads the next packet from the server.
+func (c *rconClient) receive() (rconPacket, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return rconPacket{}, err
+	}
+
+	return decodeRCONPacket(c.conn)
+}
+
+// runRCON implements the rcon subcommand, which sends a command to a running server.
+func runRCON(args []string) error {
+	fs := flag.NewFlagSet("rcon", flag.ExitOnError)
+	host := fs.String("host", "localhost", "Host of the server's RCON interface.")
+	port := fs.String("port", "25575", "Port of the server's RCON interface.")
+	password := fs.String("password", "", "RCON password of the server. Defaults to MINECRAFT_RCON_PASSWORD.")
+	timeout := fs.Duration("timeout", 5*time.Second, "Time to wait for the server to respond.")
+	fs.Parse(args)
+	if err := applySecretEnv(fs); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		return errors.New("usage: rcon [flags] <command>")
+	}
+
+	client, err := dialRCON(net.JoinHostPort(*host, *port), *password, *timeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	resp, err := client.command(strings.Join(fs.Args(), " "))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(resp)
+	return nil
+}
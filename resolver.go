@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// VersionResolver resolves a server version to the location of its jar.
+type VersionResolver interface {
+	// Resolve resolves the given version, which may be 'release',
+	// 'snapshot', or a specific version string.
+	Resolve(ctx context.Context, version string) (resolvedVersion, error)
+}
+
+// resolvedVersion is a concrete server version and where to download it.
+type resolvedVersion struct {
+	Distribution string
+	ID           string
+	Type         string
+	URL          string
+	Checksum     string
+	JavaVersion  int
+}
+
+// newResolver returns the resolver for the given distribution.
+func newResolver(distribution string) (VersionResolver, error) {
+	switch distribution {
+	case "vanilla":
+		return vanillaResolver{}, nil
+	case "paper":
+		return paperResolver{}, nil
+	case "fabric":
+		return fabricResolver{}, nil
+	case "bedrock":
+		return bedrockResolver{}, nil
+	default:
+		return nil, fmt.Errorf("invalid distribution %q", distribution)
+	}
+}
+
+// vanillaResolver resolves versions of the vanilla server from Mojang's version manifest.
+type vanillaResolver struct{}
+
+// Resolve resolves the given version using the version manifest.
+func (vanillaResolver) Resolve(ctx context.Context, id string) (resolvedVersion, error) {
+	// versionJSON contains the parsed JSON from the version information.
+	type versionJSON struct {
+		ID          string
+		Type        string
+		JavaVersion struct {
+			MajorVersion int
+		}
+		Downloads struct {
+			Server struct {
+				SHA1 string
+				URL  string
+			}
+		}
+	}
+
+	// Get the version manifest.
+	manifest, err := getManifest(ctx)
+	if err != nil {
+		return resolvedVersion{}, err
+	}
+
+	// Map 'release' and 'snapshot' keywords to the latest versions.
+	if id == "release" {
+		id = manifest.Latest.Release
+	} else if id == "snapshot" {
+		id = manifest.Latest.Snapshot
+	}
+
+	// Test if the given version is listed in the manifest.
+	for _, v := range manifest.Versions {
+		if id == v.ID {
+			// Obtain the information for the given version.
+			data, err := getBytes(ctx, v.URL)
+			if err != nil {
+				return resolvedVersion{}, err
+			}
+
+			// Verify the information against the checksum in the manifest,
+			// which only older cached manifests lack.
+			if v.SHA1 != "" {
+				sum := sha1.Sum(data)
+				if hex.EncodeToString(sum[:]) != v.SHA1 {
+					return resolvedVersion{}, fmt.Errorf("version %s information: %w", v.ID, errChecksumMismatch)
+				}
+			}
+
+			var info versionJSON
+			if err := json.Unmarshal(data, &info); err != nil {
+				return resolvedVersion{}, err
+			}
+
+			return resolvedVersion{
+				Distribution: "vanilla",
+				ID:           v.ID,
+				Type:         v.Type,
+				URL:          info.Downloads.Server.URL,
+				Checksum:     info.Downloads.Server.SHA1,
+				JavaVersion:  info.JavaVersion.MajorVersion,
+			}, nil
+		}
+	}
+
+	return resolvedVersion{}, errors.New("invalid version")
+}
+
+// paperAPI is the base URL of the PaperMC downloads API.
+const paperAPI = "https://api.papermc.io/v2/projects/paper"
+
+// paperResolver resolves versions of the Paper server from the PaperMC API.
+type paperResolver struct{}
+
+// Resolve resolves the latest stable build of the given version, where both
+// 'release' and 'snapshot' map to the newest version Paper supports.
+func (paperResolver) Resolve(ctx context.Context, id string) (resolvedVersion, error) {
+	// paperProject contains the parsed JSON from the project information.
+	type paperProject struct {
+		Versions []string
+	}
+
+	// paperBuilds contains the parsed JSON from a version's builds.
+	type paperBuilds struct {
+		Builds []struct {
+			Build     int
+			Channel   string
+			Downloads struct {
+				Application struct {
+					Name   string
+					SHA256 string
+				}
+			}
+		}
+	}
+
+	if id == "release" || id == "snapshot" {
+		var project paperProject
+		if err := getJSON(ctx, paperAPI, &project); err != nil {
+			return resolvedVersion{}, err
+		}
+		if len(project.Versions) == 0 {
+			return resolvedVersion{}, errors.New("no paper versions available")
+		}
+		id = project.Versions[len(project.Versions)-1]
+	}
+
+	var builds paperBuilds
+	if err := getJSON(ctx, paperAPI+"/versions/"+id+"/builds", &builds); err != nil {
+		return resolvedVersion{}, err
+	}
+
+	// Use the newest stable build, falling back to the newest build of any channel.
+	if len(builds.Builds) == 0 {
+		return resolvedVersion{}, errors.New("invalid version")
+	}
+	build := builds.Builds[len(builds.Builds)-1]
+	for i := len(builds.Builds) - 1; i >= 0; i-- {
+		if builds.Builds[i].Channel == "default" {
+			build = builds.Builds[i]
+			break
+		}
+	}
+
+	app := build.Downloads.Application
+	return resolvedVersion{
+		Distribution: "paper",
+		ID:           id,
+		Type:         "release",
+		URL:          fmt.Sprintf("%s/versions/%s/builds/%d/downloads/%s", paperAPI, id, build.Build, app.Name),
+		Checksum:     app.SHA256,
+	}, nil
+}
+
+// fabricAPI is the base URL of the Fabric meta API.
+const fabricAPI = "https://meta.fabricmc.net/v2/versions"
+
+// fabricResolver resolves versions of the Fabric server launcher from the Fabric meta API.
+type fabricResolver struct{}
+
+// fabricVersion is a single game, loader, or installer version from the Fabric meta API.
+type fabricVersion struct {
+	Version string
+	Stable  bool
+}
+
+// Resolve resolves the server launcher for the given game version using the
+// latest stable loader and installer. Fabric publishes no checksums.
+func (fabricResolver) Resolve(ctx context.Context, id string) (resolvedVersion, error) {
+	var games, loaders, installers []fabricVersion
+	if err := getJSON(ctx, fabricAPI+"/game", &games); err != nil {
+		return resolvedVersion{}, err
+	}
+	if err := getJSON(ctx, fabricAPI+"/loader", &loaders); err != nil {
+		return resolvedVersion{}, err
+	}
+	if err := getJSON(ctx, fabricAPI+"/installer", &installers); err != nil {
+		return resolvedVersion{}, err
+	}
+
+	// Map 'release' and 'snapshot' keywords to the latest game versions.
+	game, ok := findFabricVersion(games, func(v fabricVersion) bool {
+		switch id {
+		case "release":
+			return v.Stable
+		case "snapshot":
+			return true
+		default:
+			return v.Version == id
+		}
+	})
+	if !ok {
+		return resolvedVersion{}, errors.New("invalid version")
+	}
+
+	loader, ok := findFabricVersion(loaders, func(v fabricVersion) bool { return v.Stable })
+	if !ok {
+		return resolvedVersion{}, errors.New("no stable fabric loader available")
+	}
+
+	installer, ok := findFabricVersion(installers, func(v fabricVersion) bool { return v.Stable })
+	if !ok {
+		return resolvedVersion{}, errors.New("no stable fabric installer available")
+	}
+
+	typ := "release"
+	if !game.Stable {
+		typ = "snapshot"
+	}
+
+	return resolvedVersion{
+		Distribution: "fabric",
+		ID:           game.Version,
+		Type:         typ,
+		URL:          fmt.Sprintf("%s/loader/%s/%s/%s/server/jar", fabricAPI, game.Version, loader.Version, installer.Version),
+	}, nil
+}
+
+// findFabricVersion returns the first of the given versions, which the API
+// lists newest first, that matches the given function.
+func findFabricVersion(versions []fabricVersion, match func(fabricVersion) bool) (fabricVersion, bool) {
+	for _, v := range versions {
+		if match(v) {
+			return v, true
+		}
+	}
+
+	return fabricVersion{}, false
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// resourcePack is a resource pack served to the server's clients.
+type resourcePack struct {
+	filename string
+	sha1     string
+
+	// url is the URL clients download the pack from, and path its path,
+	// which the pack is served at.
+	url, path string
+}
+
+// newResourcePack checks the resource pack zip at filename and returns it
+// with its SHA1, which clients verify the download against, and the URL it
+// is served at under the given public base URL. If the base URL is empty,
+// it is taken from the address the pack is served on, which must then name
+// a host.
+func newResourcePack(filename, addr, publicURL string) (*resourcePack, error) {
+	if err := verifyZip(filename); err != nil {
+		return nil, fmt.Errorf("%s isn't a resource pack: %w", filename, err)
+	}
+	sum, err := fileChecksum(filename, "sha1")
+	if err != nil {
+		return nil, err
+	}
+
+	if publicURL == "" {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -resource-pack-addr %q: %w", addr, err)
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			return nil, errors.New("-serve-resource-pack requires -resource-pack-url, or a -resource-pack-addr with the host clients reach the wrapper at")
+		}
+		publicURL = "http://" + net.JoinHostPort(host, port)
+	}
+	base, err := url.Parse(publicURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid -resource-pack-url %q, must be e.g. http://play.example.com:8080", publicURL)
+	}
+
+	// The checksum in the path makes clients that cache packs by URL fetch
+	// a changed pack.
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/" + sum + "/" + filepath.Base(filename)
+	return &resourcePack{filename: filename, sha1: sum, url: base.String(), path: base.Path}, nil
+}
+
+// writeProperties points the server at the resource pack in the given
+// server.properties.
+func (p *resourcePack) writeProperties(filename string) error {
+	return updateServerProperties(filename, false, [][2]string{
+		{"resource-pack", p.url},
+		{"resource-pack-sha1", p.sha1},
+	})
+}
+
+// serve serves the resource pack on the given address until the returned
+// server is shut down. The pack is read for each download, so it mustn't
+// be changed while the server runs, as clients would reject it for no
+// longer matching its SHA1.
+func (p *resourcePack) serve(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The path is compared rather than registered with a ServeMux, whose
+	// patterns treat spaces in the pack's name as syntax.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != p.path {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			allowMethod(w, r, http.MethodGet)
+			return
+		}
+
+		f, err := os.Open(p.filename)
+		if err != nil {
+			log.Printf("warning: failed to serve resource pack: %v", err)
+			http.Error(w, "resource pack unavailable", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, "resource pack unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("ETag", `"`+p.sha1+`"`)
+		http.ServeContent(w, r, filepath.Base(p.filename), info.ModTime(), f)
+	})
+
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go srv.Serve(ln)
+
+	log.Printf("serving resource pack %s (sha1 %s) at %s", p.filename, p.sha1, p.url)
+	return srv, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// worldCorruptionPattern matches messages the server logs when it can't load
+// its world, such as a damaged level.dat or region file.
+var worldCorruptionPattern = regexp.MustCompile(`(?i)failed to (load|read) level|exception reading .*level\.dat(_old)?|couldn't load chunk|corrupt(ed)? chunk|region file .* is truncated|failed to load datapacks, can't proceed`)
+
+// earlyCrashLimit is the number of crashes in a row before the server
+// becomes ready that is taken as a sign of a damaged world.
+const earlyCrashLimit = 2
+
+// startupDetector returns a handler that records whether the server became
+// ready, and whether it reported a damaged world before then.
+func (s *server) startupDetector() logHandler {
+	return func(line logLine) {
+		switch {
+		case donePattern.MatchString(line.Message):
+			s.ready = true
+		case !s.ready && worldCorruptionPattern.MatchString(line.Message):
+			s.corrupt = true
+		}
+	}
+}
+
+// restoreWorld replaces the server's world with the latest backup marked
+// good, moving the damaged world aside rather than deleting it. It returns
+// the path of the restored backup.
+func (s *server) restoreWorld() (string, error) {
+	dir := s.dir
+	if dir == "" {
+		dir = "."
+	}
+
+	backups, err := listBackups(s.backupDir)
+	if err != nil {
+		return "", err
+	}
+
+	// Backups are listed oldest first.
+	var backup string
+	for i := len(backups) - 1; i >= 0; i-- {
+		if isGoodBackup(backups[i]) {
+			backup = backups[i]
+			break
+		}
+	}
+	if backup == "" {
+		return "", fmt.Errorf("no backup in %s is marked good, as holding a world the server loaded, to restore", s.backupDir)
+	}
+
+	suffix := ".damaged-" + time.Now().Format("20060102-150405")
+	for _, world := range worldDirs(dir) {
+		path := filepath.Join(dir, world)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := os.Rename(path, path+suffix); err != nil {
+			return "", err
+		}
+		s.logf("moved damaged world %s to %s", path, path+suffix)
+	}
+
+	if err := extractTarGz(backup, dir); err != nil {
+		return "", fmt.Errorf("restoring %s: %w", backup, err)
+	}
+
+	return backup, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cachedJarName returns the name the jar cache keeps the given version of
+// the given distribution under.
+func cachedJarName(distribution, id string) string {
+	if distribution != "vanilla" {
+		return "server-" + distribution + "-" + id + ".jar"
+	}
+
+	return "server-" + id + ".jar"
+}
+
+// runRollback switches the server back to the version installed before the
+// current one, as recorded in the version.json sidecar. The jar is restored
+// from the jar cache, or downloaded again if it isn't cached, and replaces
+// the current one atomically. Later runs stay on the version until one is
+// given explicitly.
+func runRollback(args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	filename := fs.String("filename", "server.jar", "Filename of the server.")
+	dir := fs.String("dir", ".", "Directory of the server.")
+	fs.StringVar(&jarCacheDir, "jar-cache-dir", "", "Directory of the jar cache to restore the previous version from.")
+	offline := fs.Bool("offline", false, "Only restores the previous version from the jar cache, without downloading it if it isn't cached.")
+	fs.DurationVar(&httpTimeout, "http-timeout", httpTimeout, "Timeout for HTTP requests.")
+	addProxyFlags(fs)
+	fs.StringVar(&mirror, "mirror", "", "Base URL of a mirror to download the server from.")
+	fs.Parse(args)
+
+	jar, err := filepath.Abs(filepath.Join(*dir, *filename))
+	if err != nil {
+		return err
+	}
+
+	current, err := readInstalledVersion(jar)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("can't roll back: no %s records the versions of %s", installedVersionFile, jar)
+	} else if err != nil {
+		return err
+	}
+	if len(current.History) == 0 {
+		return fmt.Errorf("can't roll back: no version before %s is recorded in %s", current.ID, installedVersionFile)
+	}
+	previous := current.History[0]
+
+	if mirror != "" {
+		if err := validateMirror(mirror); err != nil {
+			return err
+		}
+	}
+
+	if err := restorePreviousJar(previous, jar, *offline); err != nil {
+		return fmt.Errorf("rollback to %s failed: %w", previous.ID, err)
+	}
+	if err := writeChecksumFile(jar, previous.SHA1); err != nil {
+		return err
+	}
+
+	rolledBack := previous
+	rolledBack.Installed = time.Now().UTC()
+	rolledBack.RolledBack = true
+	rolledBack.History = current.History[1:]
+	if err := writeInstalledVersion(jar, rolledBack); err != nil {
+		return err
+	}
+
+	log.Printf("rolled back %s from %s to %s %s; the server stays on it until a version is given with -version", jar, current.ID, previous.Distribution, previous.ID)
+	if pinned, err := readPinnedVersion(*dir); err == nil && pinned != "" {
+		log.Printf("warning: %s gives version %s, which is used instead of the rolled back version", filepath.Join(*dir, pinnedVersionFile), pinned)
+	}
+
+	return nil
+}
+
+// restorePreviousJar replaces the jar at filename with the given previously
+// installed version, copying it from the jar cache if a matching copy is
+// there and otherwise downloading it unless offline is set.
+func restorePreviousJar(previous installedVersion, filename string, offline bool) error {
+	if jarCacheDir != "" {
+		cached := filepath.Join(jarCacheDir, cachedJarName(previous.Distribution, previous.ID))
+		if err := verifyChecksum(cached, "sha1", previous.SHA1); err == nil {
+			log.Printf("restoring %s from %s", previous.ID, cached)
+			return copyFile(cached, filename)
+		} else if !os.IsNotExist(err) {
+			log.Printf("warning: cached %s is unusable: %v", cached, err)
+		}
+	}
+	if offline {
+		return errors.New("it isn't in the jar cache, and -offline stops it from being downloaded")
+	}
+
+	httpClient = newHTTPClient(httpTimeout)
+	manifestCache = filepath.Join(filepath.Dir(filename), "version_manifest.json")
+
+	resolver, err := newResolver(previous.Distribution)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := notifyStop()
+	defer cancel()
+	version, err := resolver.Resolve(ctx, previous.ID)
+	if err != nil {
+		return err
+	}
+	if version.Checksum != "" && !strings.EqualFold(version.Checksum, previous.SHA1) && detectChecksumAlgo(version.Checksum) == "sha1" {
+		return fmt.Errorf("the published checksum of %s no longer matches the recorded sha1 %s", previous.ID, previous.SHA1)
+	}
+
+	// Download next to the jar so that it replaces it in a single rename.
+	tmp := filepath.Join(filepath.Dir(filename), ".rollback-"+filepath.Base(filename))
+	defer os.Remove(tmp)
+	url, err := mirrorURL(version.URL)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s isn't cached, downloading it", previous.ID)
+	if err := downloadFile(ctx, tmp, url, "", "", true); err != nil {
+		return err
+	}
+	if err := verifyChecksum(tmp, "sha1", previous.SHA1); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
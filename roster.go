@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// playerEventPattern matches the messages the server logs when a player
+// spawns into or leaves the world, e.g. "Steve joined the game". Earlier
+// messages about a connection, such as "logged in with entity id", are
+// ignored, as a player who disconnects before spawning never joins. Names
+// are taken to have no spaces, so that chat such as "<Steve> Alex joined the
+// game" or "[Steve] Alex left the game" doesn't match, but may otherwise
+// contain any character, as proxies for Bedrock players prefix names with
+// characters such as '.' or '*'.
+var playerEventPattern = regexp.MustCompile(`^([^\s<\[]\S*)(?: \(formerly known as \S+\))? (joined|left) the game$`)
+
+// bedrockPlayerEventPattern matches the messages the Bedrock server logs
+// when a player connects or disconnects, e.g. "Player connected: Steve,
+// xuid: 2535...". Gamertags may contain spaces but not commas.
+var bedrockPlayerEventPattern = regexp.MustCompile(`^Player (connected|disconnected): ([^,]+), xuid:`)
+
+// playerEvents returns a handler that keeps the roster of online players
+// up to date from the server's join and leave messages.
+func (m *metrics) playerEvents() logHandler {
+	return func(line logLine) {
+		// Only the server thread logs joins and leaves; a plugin logging on
+		// another thread could otherwise spoof them.
+		if line.Thread != "" && line.Thread != "Server thread" {
+			return
+		}
+
+		var name string
+		var joined bool
+		if match := playerEventPattern.FindStringSubmatch(line.Message); match != nil {
+			name, joined = match[1], match[2] == "joined"
+		} else if match := bedrockPlayerEventPattern.FindStringSubmatch(line.Message); match != nil {
+			name, joined = match[2], match[1] == "connected"
+		} else {
+			return
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if m.online == nil {
+			m.online = make(map[string]int)
+		}
+
+		// Sessions are counted rather than tracked as a set, so that a player
+		// logging in from elsewhere stays online if the new session's join is
+		// logged before the old session's leave.
+		if joined {
+			m.online[name]++
+			m.peakOnline = max(m.peakOnline, len(m.online))
+		} else if m.online[name] > 1 {
+			m.online[name]--
+		} else {
+			delete(m.online, name)
+		}
+	}
+}
+
+// resetPlayers empties the roster, as no players are online once the server
+// exits.
+func (m *metrics) resetPlayers() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.online = nil
+}
+
+// takePeakPlayers returns the largest number of players that were online at
+// once since it was last called.
+func (m *metrics) takePeakPlayers() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peak := m.peakOnline
+	m.peakOnline = len(m.online)
+	return peak
+}
+
+// playerCount returns the number of online players.
+func (m *metrics) playerCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.online)
+}
+
+// players returns the names of the online players in sorted order. The
+// caller must hold m.mu.
+func (m *metrics) players() []string {
+	names := make([]string, 0, len(m.online))
+	for name := range m.online {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// labelEscaper escapes a Prometheus label value.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writePlayers writes a series for each online player.
+func (m *metrics) writePlayers(w http.ResponseWriter) {
+	fmt.Fprintf(w, "# HELP minecraft_player_online Whether the player is online, from the server's join and leave messages.\n# TYPE minecraft_player_online gauge\n")
+	for _, name := range m.players() {
+		fmt.Fprintf(w, "minecraft_player_online{player=\"%s\"} 1\n", labelEscaper.Replace(name))
+	}
+}
+
+// servePlayers writes the names of the online players as a JSON array.
+func (m *metrics) servePlayers(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	names := m.players()
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(names)
+}
+
+// runPlayers lists the players online on a running server, from the
+// wrapper's roster if -metrics-addr is given and otherwise over RCON.
+func runPlayers(args []string) error {
+	fs := flag.NewFlagSet("players", flag.ExitOnError)
+	metricsAddr := fs.String("metrics-addr", "", "Metrics address of the wrapper running the server, whose roster is listed instead of asking the server over RCON.")
+	fs.StringVar(&rconHost, "rcon-host", "localhost", "Host of the server's RCON interface.")
+	fs.StringVar(&rconPort, "rcon-port", "25575", "Port of the server's RCON interface.")
+	fs.StringVar(&rconPassword, "rcon-password", "", "RCON password of the server. Defaults to MINECRAFT_RCON_PASSWORD.")
+	fs.Parse(args)
+	if err := applySecretEnv(fs); err != nil {
+		return err
+	}
+
+	var names []string
+	if *metricsAddr != "" {
+		var err error
+		if names, err = wrapperPlayers(*metricsAddr); err != nil {
+			return err
+		}
+	} else {
+		if !rconConfigured() {
+			return errors.New("usage: players -metrics-addr <addr> | -rcon-password <password>")
+		}
+
+		client, err := dialServerRCON()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		resp, err := client.command("list")
+		if err != nil {
+			return err
+		}
+		names = parsePlayerList(resp)
+	}
+
+	for _, name := range names {
+		fmt.Println(name)
+	}
+
+	return nil
+}
+
+// wrapperPlayers fetches the roster of a wrapper serving metrics on the
+// given address.
+func wrapperPlayers(addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + "/players")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching players from %s: %s", addr, resp.Status)
+	}
+
+	var names []string
+	if err := json.NewDecoder(resp.Body).Decode(&names); err != nil {
+		return nil, fmt.Errorf("fetching players from %s: %w", addr, err)
+	}
+
+	return names, nil
+}
+
+// parsePlayerList returns the names in the response to the list command,
+// e.g. "There are 2 of a max of 20 players online: Alex, Steve".
+func parsePlayerList(resp string) []string {
+	_, list, ok := strings.Cut(resp, ": ")
+	list = strings.TrimSpace(list)
+	if !ok || list == "" {
+		return nil
+	}
+
+	return strings.Split(list, ", ")
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// restartWarnings are how long before a scheduled restart players are warned
+// of it, longest first.
+var restartWarnings = []time.Duration{10 * time.Minute, 5 * time.Minute, time.Minute, 30 * time.Second, 10 * time.Second}
+
+// schedule is a set of times given in cron's five-field format or as a daily
+// HH:MM time, in local time.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+// cronFields are the names and ranges of the fields of a cron spec.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseSchedule parses a cron spec such as "30 4 * * 1-5", or a daily time
+// such as "04:30".
+func parseSchedule(spec string) (*schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 1 {
+		t, err := time.Parse("15:04", fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: must be HH:MM or a five-field cron spec", spec)
+		}
+		fields = []string{strconv.Itoa(t.Minute()), strconv.Itoa(t.Hour()), "*", "*", "*"}
+	}
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid schedule %q: must be HH:MM or a five-field cron spec", spec)
+	}
+
+	s := &schedule{}
+	sets := []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in schedule %q: %w", cronFields[i].name, spec, err)
+		}
+		*sets[i] = set
+	}
+
+	// Both 0 and 7 are Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	// As in cron, a field starting with * doesn't restrict the day.
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+
+	if s.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: it never occurs", spec)
+	}
+
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges such as
+// 1-5, and * for every value, each optionally followed by a /step, into the
+// set of values it matches.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value %q", first)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value %q", last)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+
+	return set, nil
+}
+
+// next returns the first time in the schedule after t, or the zero time if
+// there is none within a few years.
+func (s *schedule) next(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case s.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// matchesDay reports whether the day of t is in the schedule. As in cron, a
+// day matches either field if both the day of month and day of week are
+// restricted.
+func (s *schedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+// scheduleRestarts returns a channel that is closed once the server is due
+// for its next scheduled restart, or nil if it has no schedule. Players are
+// warned of the restart as it approaches, unless it only happens when the
+// server is empty, in which case a restart while players are online is
+// skipped. The schedule is no longer followed once done is closed.
+func (s *server) scheduleRestarts(done <-chan struct{}) <-chan struct{} {
+	if s.restartSchedule == nil {
+		return nil
+	}
+
+	due := make(chan struct{})
+	go func() {
+		wait := func(until time.Time) bool {
+			select {
+			case <-time.After(time.Until(until)):
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		for {
+			at := s.restartSchedule.next(time.Now())
+			s.logf("next scheduled restart at %s", at.Format("2006-01-02 15:04"))
+
+			if !s.restartEmptyOnly {
+				for _, warning := range restartWarnings {
+					if time.Until(at) < warning {
+						continue
+					}
+					if !wait(at.Add(-warning)) {
+						return
+					}
+					s.announce("Server restarting in " + formatCountdown(warning))
+				}
+			}
+
+			if !wait(at) {
+				return
+			}
+			if n := s.metrics.playerCount(); s.restartEmptyOnly && n > 0 {
+				s.logf("skipping scheduled restart with %d players online", n)
+				continue
+			}
+
+			close(due)
+			return
+		}
+	}()
+
+	return due
+}
+
+// announce broadcasts a message to the players on the server, over RCON if
+// it is configured and otherwise through the console.
+func (s *server) announce(msg string) {
+	if !s.rconConfigured() {
+		if err := s.console.trySend("say " + msg); err != nil {
+			s.logf("failed to announce %q: %v", msg, err)
+		}
+		return
+	}
+
+	client, err := s.dialRCON()
+	if err != nil {
+		s.logf("failed to announce %q: %v", msg, err)
+		return
+	}
+	defer client.Close()
+
+	if _, err := client.command("say " + msg); err != nil {
+		s.logf("failed to announce %q: %v", msg, err)
+	}
+}
+
+// formatCountdown formats a whole number of minutes or seconds for players,
+// e.g. "5 minutes".
+func formatCountdown(d time.Duration) string {
+	n, unit := int(d/time.Second), "second"
+	if d >= time.Minute {
+		n, unit = int(d/time.Minute), "minute"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+
+	return fmt.Sprintf("%d %s", n, unit)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// downloadParallelism is the number of byte ranges a download is split into
+// and fetched concurrently. One downloads in a single stream.
+var downloadParallelism = 1
+
+// minSegmentSize is the smallest byte range worth fetching separately.
+const minSegmentSize = 1 << 20
+
+// errSingleStream is returned by a segmented download when the server
+// doesn't serve byte ranges or the file is too small to split, so that it
+// is downloaded in a single stream instead.
+var errSingleStream = errors.New("download can't be split")
+
+// downloadSegmented downloads the given url to the given file by fetching
+// downloadParallelism byte ranges of it concurrently, writing each in
+// place. The file is removed if the download fails, as it may have holes.
+func downloadSegmented(ctx context.Context, filename, url string) error {
+	size, err := probeRanges(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	segments := min(int64(downloadParallelism), size/minSegmentSize)
+	if segments < 2 {
+		return errSingleStream
+	}
+
+	if err := checkDiskSpace(filepath.Dir(filename), size); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+
+	log.Printf("downloading %s in %d parallel segments", url, segments)
+	var progress io.Writer = io.Discard
+	if !quiet {
+		p := &progressWriter{total: size}
+		defer p.finish()
+		progress = &lockedWriter{w: p}
+	}
+
+	// The first segment to fail cancels the rest.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, segments)
+	for i := range segments {
+		start := size * i / segments
+		end := size*(i+1)/segments - 1
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = downloadRange(ctx, file, url, start, end, progress, int(segments)); errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Report the error that caused the others, rather than a cancellation.
+	var failed error
+	for _, err := range errs {
+		if err != nil && (failed == nil || errors.Is(failed, context.Canceled)) {
+			failed = err
+		}
+	}
+	if failed != nil {
+		file.Close()
+		os.Remove(filename)
+		return failed
+	}
+
+	return file.Close()
+}
+
+// probeRanges requests the first byte of the given url, returning the size
+// of the file if the server answers with the range, or errSingleStream.
+func probeRanges(ctx context.Context, url string) (int64, error) {
+	resp, cancel, err := getDownload(ctx, url, 0, 0, "")
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	defer resp.Body.Close()
+	logRedirect(resp, url)
+
+	if resp.StatusCode != http.StatusPartialContent {
+		if err := checkResponse(resp, url); err != nil {
+			return 0, err
+		}
+		log.Printf("%s doesn't support byte ranges, downloading it in a single stream", url)
+		return 0, errSingleStream
+	}
+
+	// The Content-Range has the form "bytes 0-0/<size>".
+	_, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+	size, err := strconv.ParseInt(total, 10, 64)
+	if !ok || err != nil || size <= 0 {
+		return 0, errSingleStream
+	}
+
+	return size, nil
+}
+
+// downloadRange downloads the given byte range of url into the same range of
+// file, reporting progress. The rate limit, if any, is shared evenly between
+// the given number of segments.
+func downloadRange(ctx context.Context, file *os.File, url string, start, end int64, progress io.Writer, segments int) error {
+	resp, cancel, err := getDownload(ctx, url, start, end, "")
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent || !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-%d/", start, end)) {
+		if err := checkResponse(resp, url); err != nil {
+			return err
+		}
+		return fmt.Errorf("server didn't return bytes %d-%d of %s", start, end, url)
+	}
+
+	var src io.Reader = resp.Body
+	if downloadRateLimit > 0 {
+		src = newRateLimitedReader(ctx, resp.Body, max(downloadRateLimit/int64(segments), 1))
+	}
+
+	dst := io.MultiWriter(io.NewOffsetWriter(file, start), progress)
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		return err
+	}
+	if want := end - start + 1; n != want {
+		return fmt.Errorf("truncated download: got %d of %d bytes of segment at %d", n, want, start)
+	}
+
+	return nil
+}
+
+// lockedWriter serializes writes to w from concurrent goroutines.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write writes b to the underlying writer while holding the lock.
+func (l *lockedWriter) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Write(b)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// segmentedTestSize is the size of the file served to segmented downloads,
+// which is split into four segments.
+const segmentedTestSize = 4 * minSegmentSize
+
+// setupSegmented sets the download globals for a quiet download in the
+// given number of segments, restoring them once the test ends, and returns
+// the file to serve with its SHA1.
+func setupSegmented(t *testing.T, parallelism int) ([]byte, string) {
+	t.Helper()
+
+	oldQuiet, oldParallelism, oldRetries := quiet, downloadParallelism, downloadRetries
+	t.Cleanup(func() {
+		quiet, downloadParallelism, downloadRetries = oldQuiet, oldParallelism, oldRetries
+	})
+	quiet, downloadParallelism, downloadRetries = true, parallelism, 1
+
+	data := make([]byte, segmentedTestSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	sum := sha1.Sum(data)
+
+	return data, hex.EncodeToString(sum[:])
+}
+
+// rangeStart returns the first byte of the range requested by r, or -1 if
+// it requests no range.
+func rangeStart(r *http.Request) int64 {
+	var start int64
+	if _, err := fmt.Sscanf(strings.TrimPrefix(r.Header.Get("Range"), "bytes="), "%d-", &start); err != nil {
+		return -1
+	}
+
+	return start
+}
+
+// segmentStart returns the first byte of the given one of four segments.
+func segmentStart(segment int64) int64 {
+	return segmentedTestSize * segment / 4
+}
+
+func TestDownloadSegmented(t *testing.T) {
+	data, sum := setupSegmented(t, 4)
+
+	var ranges atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		http.ServeContent(w, r, "server.jar", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	filename := filepath.Join(t.TempDir(), "server.jar")
+	if err := downloadSegmented(context.Background(), filename, srv.URL); err != nil {
+		t.Fatalf("downloadSegmented: %v", err)
+	}
+	if err := verifySHA1(filename, sum); err != nil {
+		t.Errorf("reassembled download: %v", err)
+	}
+
+	// The probe and one request per segment.
+	if got := ranges.Load(); got != 5 {
+		t.Errorf("server got %d range requests, want 5", got)
+	}
+}
+
+func TestDownloadSegmentedWithoutRanges(t *testing.T) {
+	data, sum := setupSegmented(t, 4)
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	filename := filepath.Join(t.TempDir(), "server.jar")
+	if err := downloadSegmented(context.Background(), filename, srv.URL); !errors.Is(err, errSingleStream) {
+		t.Fatalf("downloadSegmented = %v, want %v", err, errSingleStream)
+	}
+
+	// downloadFile falls back to a single stream.
+	requests.Store(0)
+	if err := downloadFile(context.Background(), filename, srv.URL, "sha1", sum, false); err != nil {
+		t.Fatalf("downloadFile: %v", err)
+	}
+	if err := verifySHA1(filename, sum); err != nil {
+		t.Errorf("single stream download: %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("server got %d requests, want the probe and a single stream", got)
+	}
+}
+
+func TestDownloadSegmentedShortSegment(t *testing.T) {
+	data, _ := setupSegmented(t, 4)
+
+	// The second segment is served with its full range but only half of it.
+	short := segmentStart(1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rangeStart(r) == short {
+			end := segmentStart(2) - 1
+			half := (end - short + 1) / 2
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", short, end, len(data)))
+			w.Header().Set("Content-Length", fmt.Sprint(half))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[short : short+half])
+			return
+		}
+		http.ServeContent(w, r, "server.jar", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	filename := filepath.Join(t.TempDir(), "server.jar")
+	err := downloadSegmented(context.Background(), filename, srv.URL)
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("downloadSegmented = %v, want a truncated download", err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("the partial download was left behind: %v", err)
+	}
+}
+
+func TestDownloadSegmentedFailureCancelsOthers(t *testing.T) {
+	data, _ := setupSegmented(t, 4)
+
+	// The third segment fails, while the others wait to be canceled.
+	failing := segmentStart(2)
+	var canceled atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch start := rangeStart(r); {
+		case r.Header.Get("Range") == "bytes=0-0":
+			http.ServeContent(w, r, "server.jar", time.Time{}, bytes.NewReader(data))
+		case start == failing:
+			http.Error(w, "segment failed", http.StatusInternalServerError)
+		default:
+			select {
+			case <-r.Context().Done():
+				canceled.Add(1)
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	filename := filepath.Join(t.TempDir(), "server.jar")
+	started := time.Now()
+	err := downloadSegmented(context.Background(), filename, srv.URL)
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("downloadSegmented = %v, want the failing segment's status", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("downloadSegmented took %s, so the other segments weren't canceled", elapsed)
+	}
+
+	// The server sees the cancellations once the connections are closed.
+	deadline := time.Now().Add(5 * time.Second)
+	for canceled.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := canceled.Load(); got != 3 {
+		t.Errorf("%d of the other 3 segments were canceled", got)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("the partial download was left behind: %v", err)
+	}
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// server is a Minecraft server supervised by the wrapper.
+type server struct {
+	// name labels the server's output and logs when several servers are
+	// supervised. It is empty when running a single server.
+	name string
+
+	// dir is the directory the server runs in, or empty for the current directory.
+	dir string
+
+	// jar is the absolute path of the server jar, and version its version
+	// if it was resolved.
+	jar     string
+	version string
+
+	// executable, if set, is the native executable of a Bedrock server,
+	// which is run instead of java.
+	executable string
+
+	// args are extra JVM arguments, and xms and xmx the initial and maximum heap sizes.
+	args     []string
+	xms, xmx string
+
+	// gcPreset names the preset of garbage collector options the server is
+	// launched with, from gcPresets.
+	gcPreset string
+
+	console *console
+	output  io.Writer
+	metrics *metrics
+
+	// restart and maxRestarts control restarting the server after crashes.
+	restart     bool
+	maxRestarts int
+
+	// backupDir, backupKeep, and backupInterval control backups of the
+	// world. The world is backed up before the server starts if backupDir
+	// is set, and every backupInterval while it runs if positive. The
+	// interval may be changed while the server runs by sending it on
+	// backupIntervals.
+	backupDir       string
+	backupKeep      int
+	backupInterval  time.Duration
+	backupIntervals chan time.Duration
+
+	// readyTimeout, if positive, is how long the server is given to become
+	// ready after starting before it is killed as hung.
+	readyTimeout time.Duration
+
+	// crashArchiveDir, if set, is the directory crash reports written by
+	// crashes are copied to.
+	crashArchiveDir string
+
+	// integrityInterval, if positive, is the interval at which the jar is
+	// verified against its recorded checksum while the server runs.
+	integrityInterval time.Duration
+
+	// rconAddr and rconPassword locate the server's RCON interface, used to
+	// pause saves during live backups and to announce restarts. It isn't
+	// used if the password is empty.
+	rconAddr, rconPassword string
+
+	// autoRestore restores the world from the latest backup, once per run,
+	// if the server fails to start because its world appears damaged.
+	autoRestore bool
+
+	// restartSchedule, if set, gives the times the server is restarted at,
+	// and restartEmptyOnly skips those at which players are online.
+	restartSchedule  *schedule
+	restartEmptyOnly bool
+
+	// idleTimeout, if positive, stops the server once no players have been
+	// online for that long, and wakeOnConnect then starts it again when a
+	// player tries to join.
+	idleTimeout   time.Duration
+	wakeOnConnect bool
+
+	// heapTable, if set, picks the maximum heap at planned restarts by the
+	// peak number of players online since the last one.
+	heapTable []heapTier
+
+	// restartRequests restarts the running server when sent to, or is nil
+	// if nothing can request restarts. It is unbuffered, so that a request
+	// made while no server is running fails rather than restarting the next
+	// one.
+	restartRequests chan struct{}
+
+	// ready and corrupt record whether the current start of the server
+	// became ready, and whether it reported a damaged world before then.
+	ready, corrupt bool
+}
+
+// forceStop is closed once the wrapper receives a second stop signal, which
+// kills the servers rather than waiting for them to stop.
+var forceStop = make(chan struct{})
+
+// notifyStop returns a context that is canceled once the wrapper receives a
+// stop signal, which cancels any downloads in progress and stops the
+// servers run with its Done channel. A second signal closes forceStop.
+// Signals are handled until the returned function is called, so that one
+// received while the servers stop doesn't kill the wrapper and leave them
+// running.
+func notifyStop() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	released := make(chan struct{})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		for received := 0; ; received++ {
+			select {
+			case sig := <-signals:
+				switch received {
+				case 0:
+					log.Printf("received %s, stopping", sig)
+					cancel()
+				case 1:
+					log.Printf("received %s again, killing the server", sig)
+					close(forceStop)
+				default:
+					log.Printf("received %s, already killing the server", sig)
+				}
+			case <-released:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() { close(released) })
+		cancel()
+	}
+}
+
+// logf logs a message about the server, labelled with its name if it has one.
+func (s *server) logf(format string, v ...interface{}) {
+	if s.name != "" {
+		format = s.name + ": " + format
+	}
+	log.Printf(format, v...)
+}
+
+// run backs up and runs the server until it exits or stop is closed,
+// restarting it after crashes if enabled.
+func (s *server) run(stop <-chan struct{}) error {
+	// The backup taken before starting holds a world that hasn't been seen
+	// to load yet, so it is only marked good, to be restored, once the
+	// server has become ready.
+	var unverified string
+	if s.backupDir != "" {
+		name, err := s.runBackup()
+		if err != nil {
+			return err
+		}
+		unverified = name
+	}
+
+	// Periodically back up the running server.
+	if s.backupDir != "" {
+		done := make(chan struct{})
+		defer close(done)
+		go s.backupLoop(done)
+	}
+
+	// Periodically verify the jar the server is restarted from.
+	if s.integrityInterval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go s.integrityLoop(done)
+	}
+
+	restored := false
+	earlyCrashes := 0
+
+	// Scheduled restarts and restarts after being idle don't count towards
+	// the limit on restarts after crashes.
+	plannedRestarts := 0
+
+	for restarts := 0; ; restarts++ {
+		s.metrics.setRestarts(restarts)
+
+		if err := s.runPreStartHook(); err != nil {
+			return err
+		}
+
+		// The server is also stopped when a scheduled restart is due, it has
+		// been idle for too long, or a restart is requested.
+		done := make(chan struct{})
+		restartDue := s.scheduleRestarts(done)
+		idle := s.watchIdle(done)
+		requested := make(chan struct{})
+		serverStop := make(chan struct{})
+		go func() {
+			select {
+			case <-stop:
+			case <-restartDue:
+			case <-idle:
+			case <-s.restartRequests:
+				close(requested)
+			case <-done:
+				return
+			}
+			close(serverStop)
+		}()
+		started := time.Now()
+		stopped, err := s.start(serverStop)
+		close(done)
+
+		code := exitCode(err)
+		crashed := err != nil && !stopped
+		if crashed {
+			event := webhookEvent{Event: "crash", ExitCode: &code}
+			if report := s.newestCrashReport(started); report != "" {
+				s.logf("server wrote crash report %s", report)
+				if s.crashArchiveDir != "" {
+					s.archiveCrashReport(report)
+				}
+				if webhookCrashReport {
+					event.CrashReport = readCrashReport(report)
+				}
+			}
+			s.notifyEvent(event)
+		} else {
+			s.notify("stop", &code)
+		}
+
+		// Run the post-stop hook before deciding whether to restart, so that
+		// it sees the files as the server left them, e.g. to back up a crash.
+		s.runPostStopHook(code, crashed)
+
+		if s.ready {
+			if unverified != "" {
+				s.markBackupGood(unverified)
+				unverified = ""
+			}
+			earlyCrashes = 0
+		} else if crashed {
+			earlyCrashes++
+		}
+
+		// Restore the world and retry once if it appears to be damaged.
+		if crashed && s.autoRestore && !restored && (s.corrupt || earlyCrashes >= earlyCrashLimit) {
+			restored = true
+			backup, restoreErr := s.restoreWorld()
+			if restoreErr == nil {
+				s.logf("!!! the world appears to be damaged; restored it from %s and retrying !!!", backup)
+				continue
+			}
+			s.logf("!!! the world appears to be damaged but restoring it failed: %v !!!", restoreErr)
+		}
+
+		if stopped && !isClosed(stop) {
+			switch {
+			case isClosed(restartDue):
+				plannedRestarts++
+				s.logf("restarting server on schedule")
+				s.resizeHeap()
+				continue
+			case isClosed(requested):
+				plannedRestarts++
+				s.logf("restarting server on request")
+				continue
+			case isClosed(idle):
+				if !s.wakeOnConnect {
+					return err
+				}
+				joined, sleepErr := s.sleepUntilJoin(stop)
+				if sleepErr != nil {
+					return sleepErr
+				}
+				if !joined {
+					return err
+				}
+				plannedRestarts++
+				s.resizeHeap()
+				continue
+			}
+		}
+
+		crashRestarts := restarts - plannedRestarts
+		if err == nil || stopped || !s.restart || crashRestarts >= s.maxRestarts {
+			return err
+		}
+
+		s.logf("server exited with %v; restarting in %s (attempt %d/%d)", err, restartDelay, crashRestarts+1, s.maxRestarts)
+		select {
+		case <-stop:
+			return err
+		case <-time.After(restartDelay):
+		}
+	}
+}
+
+// isClosedPipe reports whether err is from reading or writing a pipe that
+// was closed, as happens to the server's output during shutdown.
+func isClosedPipe(err error) bool {
+	return errors.Is(err, os.ErrClosed) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, syscall.EPIPE)
+}
+
+// isClosed reports whether the given channel is closed, which a nil
+// channel never is.
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// backupLoop backs up the running server every backupInterval, if
+// positive, until done is closed, picking up changes to the interval.
+func (s *server) backupLoop(done <-chan struct{}) {
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	reset := func(interval time.Duration) {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		if interval > 0 {
+			ticker = time.NewTicker(interval)
+			tick = ticker.C
+		}
+	}
+	reset(s.backupInterval)
+	defer reset(0)
+
+	for {
+		select {
+		case <-tick:
+			if err := s.runLiveBackup(); err != nil {
+				s.logf("backup failed: %v", err)
+			}
+		case interval := <-s.backupIntervals:
+			reset(interval)
+		case <-done:
+			return
+		}
+	}
+}
+
+// setBackupInterval changes the interval between backups of the running
+// server. It has no effect unless the server has a backup directory.
+func (s *server) setBackupInterval(interval time.Duration) {
+	select {
+	case s.backupIntervals <- interval:
+	default:
+	}
+}
+
+// start starts the server and waits for it to exit, asking it to stop once
+// stop is closed. It reports whether the server exited because a stop was
+// requested.
+func (s *server) start(stop <-chan struct{}) (bool, error) {
+	args := s.command()
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = s.dir
+	cmd.Env = s.environ()
+	configureProcess(cmd)
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return false, err
+	}
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+
+	errOut, err := cmd.StderrPipe()
+	if err != nil {
+		return false, err
+	}
+
+	// Start the server.
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+	s.metrics.setStarted(time.Now())
+	defer s.metrics.setStarted(time.Time{})
+	s.metrics.resetPlayers()
+	defer s.metrics.resetPlayers()
+	go s.notify("start", nil)
+
+	// Forward console input to the server until it exits.
+	done := make(chan struct{})
+	go s.console.forward(in, done)
+
+	// Run the on-ready hook and notify the webhook once the server has started.
+	s.ready, s.corrupt = false, false
+	becameReady := make(chan struct{})
+	handlers := []logHandler{s.startupDetector(), s.metrics.playerEvents(), readyDetector(func(duration string) {
+		close(becameReady)
+		go s.runReadyHook(duration)
+		go s.notify("ready", nil)
+	})}
+
+	// Kill a server that hangs while starting, e.g. loading the world.
+	var readyDeadline <-chan time.Time
+	if s.readyTimeout > 0 {
+		timer := time.NewTimer(s.readyTimeout)
+		defer timer.Stop()
+		readyDeadline = timer.C
+	}
+
+	// Copy server output to stdout, reporting any error once it ends.
+	copied := make(chan error, 1)
+	go func() {
+		copied <- processOutput(out, errOut, s.output, s.name, handlers)
+	}()
+
+	// Wait for server to exit once all output has been read. Failing to
+	// copy output doesn't stop the server, as processOutput keeps draining
+	// both pipes, discarding what it can't process.
+	exited := make(chan error, 1)
+	go func() {
+		if err := <-copied; err != nil && !isClosedPipe(err) {
+			s.logf("warning: failed to copy server output: %v", err)
+		}
+		exited <- cmd.Wait()
+		close(done)
+	}()
+
+wait:
+	for {
+		select {
+		case err := <-exited:
+			return false, err
+		case <-becameReady:
+			becameReady, readyDeadline = nil, nil
+		case <-readyDeadline:
+			s.logf("server didn't become ready within %s, killing it", s.readyTimeout)
+			s.metrics.addReadyTimeout()
+			if err := cmd.Process.Kill(); err != nil {
+				return false, err
+			}
+			<-exited
+			return false, errReadyTimeout
+		case <-stop:
+			s.logf("stopping server")
+			break wait
+		}
+	}
+
+	// Ask the server to stop, killing it if it doesn't within the timeout.
+	if err := s.console.trySend("stop"); err != nil {
+		s.logf("warning: can't ask the server to stop: %v", err)
+	}
+
+	select {
+	case err := <-exited:
+		return true, err
+	case <-forceStop:
+		s.logf("killing server")
+		if err := killProcessGroup(cmd); err != nil {
+			return true, err
+		}
+		<-exited
+		return true, errKilled
+	case <-time.After(stopTimeout):
+		if err := cmd.Process.Kill(); err != nil {
+			return true, err
+		}
+		<-exited
+		return true, errForcedKill
+	}
+}
+
+// prefixWriter writes a prefix at the start of every line written to w, so
+// that the output of several servers can be told apart.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+}
+
+// newPrefixWriter returns a writer that prefixes each line written to w.
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+// Write writes b to the underlying writer in a single call, prefixing each
+// line that starts in it.
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !p.midLine {
+			buf.Write(p.prefix)
+		}
+		buf.Write(line)
+		p.midLine = line[len(line)-1] != '\n'
+	}
+
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// timestampOutput prefixes each line of server output with the wall-clock
+// time, since the server's own timestamps have no date.
+var timestampOutput bool
+
+// logLinePattern matches the standard server log format, e.g.
+// "[12:34:56] [Server thread/INFO]: message".
+var logLinePattern = regexp.MustCompile(`^\[(\d{2}:\d{2}:\d{2})\] \[([^\]]*)/([A-Z]+)\]: (.*)$`)
+
+// bedrockLogLinePattern matches the Bedrock server's log format, e.g.
+// "[2024-01-02 12:34:56:789 INFO] message", which names no thread.
+var bedrockLogLinePattern = regexp.MustCompile(`^\[(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}):\d+ ([A-Z]+)\] (.*)$`)
+
+// donePattern matches the message logged once the server has started, e.g.
+// `Done (12.345s)! For help, type "help"`, capturing the startup duration,
+// or "Server started." from the Bedrock server, which logs no duration.
+var donePattern = regexp.MustCompile(`^(?:Done \(([\d.]+)s\)!|Server started\.$)`)
+
+// logLine is a single line of server output in structured form.
+type logLine struct {
+	Time      string `json:"time,omitempty"`
+	Server    string `json:"server,omitempty"`
+	Stream    string `json:"stream,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Thread    string `json:"thread,omitempty"`
+	Level     string `json:"level,omitempty"`
+	Message   string `json:"message"`
+	Raw       string `json:"-"`
+}
+
+// logHandler is called with each line of server output.
+type logHandler func(line logLine)
+
+// parseLogLine parses a line of server output. Lines that match neither the
+// standard nor the Bedrock format are returned with only the message set.
+func parseLogLine(raw string) logLine {
+	if m := logLinePattern.FindStringSubmatch(raw); m != nil {
+		return logLine{Timestamp: m[1], Thread: m[2], Level: m[3], Message: m[4], Raw: raw}
+	}
+	if m := bedrockLogLinePattern.FindStringSubmatch(raw); m != nil {
+		return logLine{Timestamp: m[1], Level: m[2], Message: m[3], Raw: raw}
+	}
+
+	return logLine{Message: raw, Raw: raw}
+}
+
+// readyDetector returns a handler that calls onReady with the startup
+// duration the first time the server reports that it is ready.
+func readyDetector(onReady func(duration string)) logHandler {
+	var once sync.Once
+	started := time.Now()
+	return func(line logLine) {
+		if m := donePattern.FindStringSubmatch(line.Message); m != nil {
+			duration := m[1]
+			if duration == "" {
+				duration = fmt.Sprintf("%.3f", time.Since(started).Seconds())
+			}
+			once.Do(func() { onReady(duration) })
+		}
+	}
+}
+
+// processOutput reads the server's stdout and stderr a line at a time,
+// passing each parsed line to the handlers and writing it to w, as JSON if
+// jsonLogs is set. Lines from both streams are handled one at a time, and
+// stderr lines are tagged as such. The JSON lines are labelled with the
+// server's name if it isn't empty. Lines are prefixed with the time they
+// were read if timestampOutput is set.
+func processOutput(stdout, stderr io.Reader, w io.Writer, name string, handlers []logHandler) error {
+	lines := make(chan logLine)
+	done := make(chan error)
+	scan := func(r io.Reader, stream string) {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := parseLogLine(scanner.Text())
+			line.Server = name
+			line.Stream = stream
+			if timestampOutput {
+				line.Time = time.Now().Format(time.RFC3339)
+			}
+			lines <- line
+		}
+
+		// A scan error, such as a line too long to buffer, stops the
+		// scanner, so discard the rest of the stream rather than leaving
+		// the server blocked on a full pipe.
+		err := scanner.Err()
+		if err != nil {
+			io.Copy(io.Discard, r)
+		}
+		done <- err
+	}
+	go scan(stdout, "")
+	go scan(stderr, "stderr")
+
+	// Keep reading both streams after an error so the server never blocks.
+	var err error
+	for open := 2; open > 0; {
+		select {
+		case line := <-lines:
+			for _, handle := range handlers {
+				handle(line)
+			}
+			if err == nil {
+				err = writeLogLine(w, line)
+			}
+		case scanErr := <-done:
+			open--
+			if err == nil {
+				err = scanErr
+			}
+		}
+	}
+
+	return err
+}
+
+// writeLogLine writes a line of server output to w.
+func writeLogLine(w io.Writer, line logLine) error {
+	if jsonLogs {
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+
+	raw := line.Raw
+	if line.Stream != "" {
+		raw = "[" + line.Stream + "] " + raw
+	}
+	if timestampOutput {
+		raw = line.Time + " " + raw
+	}
+
+	_, err := fmt.Fprintln(w, raw)
+	return err
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// instanceConfig describes one of the servers in a -servers file. Keys are
+// named after the flags they correspond to.
+type instanceConfig struct {
+	Name           string   `json:"name"`
+	Dir            string   `json:"dir"`
+	Version        string   `json:"version"`
+	Distribution   string   `json:"distribution"`
+	Port           int      `json:"port"`
+	Xms            string   `json:"xms"`
+	Xmx            string   `json:"xmx"`
+	GCPreset       string   `json:"gc-preset"`
+	Args           []string `json:"args"`
+	Restart        bool     `json:"restart"`
+	MaxRestarts    *int     `json:"max-restarts"`
+	BackupDir      string   `json:"backup-dir"`
+	BackupKeep     int      `json:"backup-keep"`
+	BackupInterval string   `json:"backup-interval"`
+	AutoRestore    bool     `json:"auto-restore"`
+
+	RestartSchedule  string `json:"restart-schedule"`
+	RestartEmptyOnly bool   `json:"restart-empty-only"`
+	IdleTimeout      string `json:"idle-timeout"`
+	WakeOnConnect    bool   `json:"wake-on-connect"`
+	DynamicMemory    string `json:"dynamic-memory"`
+	CrashArchiveDir  string `json:"crash-archive-dir"`
+	ReadyTimeout     string `json:"ready-timeout"`
+	RCONPort         int    `json:"rcon-port"`
+	RCONPassword     string `json:"rcon-password"`
+}
+
+// instanceOptions are the settings shared by every server in a -servers file.
+type instanceOptions struct {
+	acceptEULA     bool
+	offline        bool
+	doVersionCheck bool
+	skipJavaCheck  bool
+	noStdin        bool
+
+	// integrityInterval is the interval at which each server's jar is
+	// verified while it runs, if positive.
+	integrityInterval time.Duration
+}
+
+// loadInstances reads the servers described by the given JSON file, which
+// holds an array of instance configs, filling in defaults.
+func loadInstances(filename string) ([]instanceConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []instanceConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&instances); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("%s: no servers defined", filename)
+	}
+
+	names := make(map[string]bool)
+	for i := range instances {
+		inst := &instances[i]
+		switch {
+		case inst.Name == "":
+			return nil, fmt.Errorf("%s: server %d has no name", filename, i+1)
+		case strings.ContainsAny(inst.Name, " \t"):
+			return nil, fmt.Errorf("%s: server name %q contains whitespace", filename, inst.Name)
+		case names[inst.Name]:
+			return nil, fmt.Errorf("%s: duplicate server name %q", filename, inst.Name)
+		}
+		names[inst.Name] = true
+
+		if inst.Dir == "" {
+			inst.Dir = inst.Name
+		}
+		if inst.Version == "" {
+			pinned, err := readPinnedVersion(inst.Dir)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", filename, inst.Name, err)
+			}
+			inst.Version = pinned
+		}
+		if rolledBack, ok := rolledBackVersion(filepath.Join(inst.Dir, "server.jar")); ok && inst.Version == "" {
+			log.Printf("%s: staying on %s, which was rolled back to; give a version to change it", inst.Name, rolledBack.ID)
+			inst.Version = rolledBack.ID
+			if inst.Distribution == "" {
+				inst.Distribution = rolledBack.Distribution
+			}
+		}
+		if inst.Version == "" {
+			inst.Version = "release"
+		}
+		if inst.Distribution == "" {
+			inst.Distribution = "vanilla"
+		}
+		if inst.GCPreset == "" {
+			inst.GCPreset = "none"
+		}
+		if inst.MaxRestarts == nil {
+			maxRestarts := 5
+			inst.MaxRestarts = &maxRestarts
+		}
+
+		if err := validateGCPreset(inst.GCPreset); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", filename, inst.Name, err)
+		}
+		for _, size := range []string{inst.Xms, inst.Xmx} {
+			if err := validateMemorySize(size); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", filename, inst.Name, err)
+			}
+		}
+		if inst.Port != 0 {
+			if err := validatePort(inst.Port); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", filename, inst.Name, err)
+			}
+		}
+		if inst.BackupInterval != "" {
+			if _, err := time.ParseDuration(inst.BackupInterval); err != nil {
+				return nil, fmt.Errorf("%s: %s: invalid backup-interval: %w", filename, inst.Name, err)
+			}
+			if inst.BackupDir == "" {
+				return nil, fmt.Errorf("%s: %s: backup-interval requires backup-dir", filename, inst.Name)
+			}
+		}
+		if (inst.RCONPort == 0) != (inst.RCONPassword == "") {
+			return nil, fmt.Errorf("%s: %s: rcon-port and rcon-password must be given together", filename, inst.Name)
+		} else if inst.RCONPort != 0 {
+			if err := validatePort(inst.RCONPort); err != nil {
+				return nil, fmt.Errorf("%s: %s: invalid rcon-port: %w", filename, inst.Name, err)
+			}
+		}
+		if inst.AutoRestore && inst.BackupDir == "" {
+			return nil, fmt.Errorf("%s: %s: auto-restore requires backup-dir", filename, inst.Name)
+		}
+		if inst.RestartSchedule != "" {
+			if _, err := parseSchedule(inst.RestartSchedule); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", filename, inst.Name, err)
+			}
+		} else if inst.RestartEmptyOnly {
+			return nil, fmt.Errorf("%s: %s: restart-empty-only requires restart-schedule", filename, inst.Name)
+		}
+		if inst.IdleTimeout != "" {
+			if _, err := time.ParseDuration(inst.IdleTimeout); err != nil {
+				return nil, fmt.Errorf("%s: %s: invalid idle-timeout: %w", filename, inst.Name, err)
+			}
+		} else if inst.WakeOnConnect {
+			return nil, fmt.Errorf("%s: %s: wake-on-connect requires idle-timeout", filename, inst.Name)
+		}
+		if inst.ReadyTimeout != "" {
+			if _, err := time.ParseDuration(inst.ReadyTimeout); err != nil {
+				return nil, fmt.Errorf("%s: %s: invalid ready-timeout: %w", filename, inst.Name, err)
+			}
+		}
+		if inst.DynamicMemory != "" {
+			if inst.RestartSchedule == "" && !inst.WakeOnConnect {
+				return nil, fmt.Errorf("%s: %s: dynamic-memory requires restart-schedule or wake-on-connect", filename, inst.Name)
+			}
+			if _, err := parseHeapTable(inst.DynamicMemory, inst.Xms); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", filename, inst.Name, err)
+			}
+		}
+	}
+
+	return instances, nil
+}
+
+// prepareInstance downloads and configures the given server so that it is
+// ready to run, returning it.
+func prepareInstance(ctx context.Context, inst instanceConfig, opts instanceOptions, output io.Writer) (*server, error) {
+	if err := prepareDir(inst.Dir); err != nil {
+		return nil, err
+	}
+
+	jar, err := filepath.Abs(filepath.Join(inst.Dir, "server.jar"))
+	if err != nil {
+		return nil, err
+	}
+
+	var version string
+	if opts.offline {
+		if err := verifyOffline(jar); err != nil {
+			return nil, err
+		}
+	} else if opts.doVersionCheck {
+		resolver, err := newResolver(inst.Distribution)
+		if err != nil {
+			return nil, err
+		}
+
+		resolved, err := getVersion(ctx, resolver, inst.Version, jar)
+		if err != nil {
+			return nil, err
+		}
+		version = resolved.ID
+
+		if !opts.skipJavaCheck {
+			if err := checkJavaVersion(javaPath, resolved.JavaVersion); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	eula := filepath.Join(inst.Dir, "eula.txt")
+	if opts.acceptEULA {
+		if err := acceptEULA(eula); err != nil {
+			return nil, err
+		}
+	} else {
+		accepted, err := checkEULA(eula)
+		if err != nil {
+			return nil, err
+		}
+		if !accepted {
+			return nil, fmt.Errorf("the Minecraft EULA must be accepted before the server can start; read %s and rerun with -accept-eula or set eula=true in %s", eulaURL, eula)
+		}
+	}
+
+	if inst.Port != 0 {
+		if err := setServerPort(filepath.Join(inst.Dir, "server.properties"), inst.Port); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkPortFree(filepath.Join(inst.Dir, "server.properties")); err != nil {
+		return nil, err
+	}
+
+	if err := chownToRunAs(inst.Dir, jar); err != nil {
+		return nil, err
+	}
+
+	// The durations were validated when the config was loaded, and are zero if unset.
+	backupInterval, _ := time.ParseDuration(inst.BackupInterval)
+	idleTimeout, _ := time.ParseDuration(inst.IdleTimeout)
+	readyTimeout, _ := time.ParseDuration(inst.ReadyTimeout)
+
+	var restartSchedule *schedule
+	if inst.RestartSchedule != "" {
+		// The schedule was also validated when the config was loaded.
+		if restartSchedule, err = parseSchedule(inst.RestartSchedule); err != nil {
+			return nil, err
+		}
+	}
+
+	var heapTable []heapTier
+	if inst.DynamicMemory != "" {
+		// The table was also validated when the config was loaded.
+		if heapTable, err = parseHeapTable(inst.DynamicMemory, inst.Xms); err != nil {
+			return nil, err
+		}
+	}
+
+	c := newConsole()
+	return &server{
+		name:           inst.Name,
+		dir:            inst.Dir,
+		jar:            jar,
+		version:        version,
+		args:           inst.Args,
+		xms:            inst.Xms,
+		xmx:            inst.Xmx,
+		gcPreset:       inst.GCPreset,
+		console:        c,
+		output:         io.MultiWriter(newPrefixWriter(output, "["+inst.Name+"] "), c),
+		metrics:        &metrics{},
+		restart:        inst.Restart,
+		maxRestarts:    *inst.MaxRestarts,
+		backupDir:      inst.BackupDir,
+		backupKeep:     inst.BackupKeep,
+		backupInterval: backupInterval,
+		autoRestore:    inst.AutoRestore,
+
+		restartSchedule:  restartSchedule,
+		restartEmptyOnly: inst.RestartEmptyOnly,
+		idleTimeout:      idleTimeout,
+		wakeOnConnect:    inst.WakeOnConnect,
+		heapTable:        heapTable,
+
+		readyTimeout:      readyTimeout,
+		crashArchiveDir:   inst.CrashArchiveDir,
+		integrityInterval: opts.integrityInterval,
+		rconAddr:          net.JoinHostPort("localhost", strconv.Itoa(inst.RCONPort)),
+		rconPassword:      inst.RCONPassword,
+	}, nil
+}
+
+// runServers runs every server described by the given -servers file,
+// supervising each with its own restart and backup policy and stopping them
+// all on a stop signal. It returns once every server has exited.
+func runServers(ctx context.Context, filename string, output io.Writer, opts instanceOptions) error {
+	instances, err := loadInstances(filename)
+	if err != nil {
+		return err
+	}
+
+	// Servers are prepared one at a time so download progress stays readable.
+	var servers []*server
+	for _, inst := range instances {
+		srv, err := prepareInstance(ctx, inst, opts, output)
+		if err != nil {
+			return fmt.Errorf("%s: %w", inst.Name, err)
+		}
+		servers = append(servers, srv)
+	}
+
+	// Route stdin to the servers.
+	if !opts.noStdin {
+		go func() {
+			if err := routeInput(os.Stdin, servers); err != nil {
+				log.Printf("failed to read stdin: %v", err)
+			}
+		}()
+	}
+
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(i int, srv *server) {
+			defer wg.Done()
+			if err := srv.run(ctx.Done()); err != nil {
+				srv.logf("server exited with %v", err)
+				errs[i] = fmt.Errorf("%s: %w", srv.name, err)
+			}
+		}(i, srv)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// routeInput reads lines of the form "<name> <command>" from r and sends
+// each command to the console of the named server.
+func routeInput(r io.Reader, servers []*server) error {
+	consoles := make(map[string]*console)
+	for _, srv := range servers {
+		consoles[srv.name] = srv.console
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		name, command, _ := strings.Cut(line, " ")
+		c, ok := consoles[name]
+		if !ok {
+			log.Printf("unknown server %q; prefix commands with a server name, e.g. %q", name, servers[0].name+" list")
+			continue
+		}
+		if command = strings.TrimSpace(command); command != "" {
+			c.sendInput(command)
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runUpdate brings the server jar up to date with a version without
+// launching it, or with -check-only reports whether an update is available.
+func runUpdate(args []string) error {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	filename := fs.String("filename", "server.jar", "Filename of the server.")
+	dir := fs.String("dir", ".", "Directory of the server.")
+	version := fs.String("version", "release", "Minecraft version to update to. Must be 'release', 'snapshot', or a specific version string. Defaults to the version in .mcversion in -dir if it exists, and to 'release' otherwise. Required after a rollback.")
+	distribution := fs.String("distribution", "vanilla", "Server distribution to use. Must be 'vanilla' (default), 'paper', or 'fabric'.")
+	checkOnly := fs.Bool("check-only", false, "Reports whether the installed server matches the available version without downloading it.")
+	fs.BoolVar(&quiet, "quiet", false, "Suppresses download progress output.")
+	rateLimit := fs.String("download-rate-limit", "0", "Maximum download speed in bytes per second, e.g. 2M. Zero means unlimited.")
+	fs.DurationVar(&httpTimeout, "http-timeout", httpTimeout, "Timeout for HTTP requests.")
+	addProxyFlags(fs)
+	fs.DurationVar(&manifestCacheTTL, "manifest-cache-ttl", time.Hour, "Time the cached version manifest is used before it is refetched.")
+	fs.IntVar(&downloadRetries, "download-retries", 3, "Number of attempts made to download the server.")
+	fs.IntVar(&downloadParallelism, "download-parallelism", 1, "Number of byte ranges to split downloads into and fetch concurrently, if the server supports ranges. One downloads in a single stream.")
+	fs.BoolVar(&forceDownload, "force-download", false, "Downloads the server again even if it is already up to date.")
+	allowedVersionsList := fs.String("allowed-versions", "", "Comma-separated version IDs or patterns, e.g. 1.20.*, that the version must match. Empty allows any version.")
+	fs.BoolVar(&allowSnapshot, "allow-snapshot", false, "Allows updating to a snapshot version without asking for confirmation.")
+	fs.StringVar(&mirror, "mirror", "", "Base URL of a mirror to download the server from.")
+	fs.Var(&certPins, "pin-cert", "Requires the certificates of download servers to have the given SHA-256 fingerprint, given as [host=]fingerprint. May be repeated.")
+	fs.Parse(args)
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["version"] {
+		pinned, err := readPinnedVersion(*dir)
+		if err != nil {
+			return err
+		}
+		if pinned != "" {
+			*version = pinned
+		} else if rolledBack, ok := rolledBackVersion(filepath.Join(*dir, *filename)); ok {
+			return fmt.Errorf("%s was rolled back to %s; give -version to update it", filepath.Join(*dir, *filename), rolledBack.ID)
+		}
+	}
+
+	if err := setDownloadRateLimit(*rateLimit); err != nil {
+		return err
+	}
+
+	if downloadParallelism < 1 {
+		return fmt.Errorf("invalid download parallelism %d, must be at least 1", downloadParallelism)
+	}
+
+	if err := setAllowedVersions(*allowedVersionsList); err != nil {
+		return err
+	}
+
+	if mirror != "" {
+		if err := validateMirror(mirror); err != nil {
+			return err
+		}
+	}
+	httpClient = newHTTPClient(httpTimeout)
+	manifestCache = filepath.Join(*dir, "version_manifest.json")
+
+	resolver, err := newResolver(*distribution)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	resolved, err := resolver.Resolve(ctx, *version)
+	if err != nil {
+		return err
+	}
+
+	jar, err := filepath.Abs(filepath.Join(*dir, *filename))
+	if err != nil {
+		return err
+	}
+
+	if *checkOnly {
+		fmt.Printf("Available: %s (%s %s)\n", resolved.ID, *distribution, resolved.Type)
+		_, statErr := os.Stat(jar)
+		switch {
+		case os.IsNotExist(statErr):
+			fmt.Println("Installed: none")
+		case resolved.Checksum == "":
+			fmt.Println("Installed: unknown, no checksum is available to compare against")
+		case verifyChecksum(jar, checksumAlgo, resolved.Checksum) == nil:
+			fmt.Println("Installed: up to date")
+		default:
+			fmt.Println("Installed: out of date")
+		}
+		return nil
+	}
+
+	if err := checkAllowedVersion(resolved.ID); err != nil {
+		return err
+	}
+
+	if err := confirmSnapshot(resolved); err != nil {
+		return err
+	}
+
+	if err := prepareDir(*dir); err != nil {
+		return err
+	}
+
+	downloaded, err := installVersion(ctx, &resolved, jar)
+	if err != nil {
+		return fmt.Errorf("update to %s failed: %w", resolved.ID, err)
+	}
+	recordVersion(resolved, jar)
+
+	if downloaded {
+		log.Printf("downloaded %s to %s", resolved.ID, jar)
+	} else {
+		log.Printf("%s is already up to date with %s", jar, resolved.ID)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// listVersions prints the versions in the manifest, newest first, limited
+// to the given type if it isn't empty.
+func listVersions(ctx context.Context, typ string) error {
+	manifest, err := getManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	versions := manifest.Versions
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].ReleaseTime.After(versions[j].ReleaseTime)
+	})
+
+	for _, v := range versions {
+		if typ != "" && v.Type != typ {
+			continue
+		}
+		fmt.Printf("%-24s %-10s %s\n", v.ID, v.Type, v.ReleaseTime.Format("2006-01-02"))
+	}
+
+	return nil
+}
+
+// optionalStringFlag is a flag that may be given with or without a value.
+type optionalStringFlag struct {
+	set   bool
+	value string
+}
+
+// String returns the flag's value.
+func (f *optionalStringFlag) String() string {
+	return f.value
+}
+
+// Set records that the flag was given, keeping the value unless it is the
+// implicit "true" passed when the flag has no value.
+func (f *optionalStringFlag) Set(s string) error {
+	f.set = true
+	if s != "true" {
+		f.value = s
+	}
+
+	return nil
+}
+
+// IsBoolFlag allows the flag to be given without a value.
+func (f *optionalStringFlag) IsBoolFlag() bool {
+	return true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// webhook holds the webhook configuration, which may be changed by a
+// config reload while the server runs.
+var webhook struct {
+	mu sync.Mutex
+
+	// url receives a POST for each server lifecycle event, if set.
+	url string
+
+	// template shapes the webhook payload, which is the JSON-encoded event
+	// if nil.
+	template *template.Template
+}
+
+// setWebhook sets the webhook URL and payload template.
+func setWebhook(url string, tmpl *template.Template) {
+	webhook.mu.Lock()
+	defer webhook.mu.Unlock()
+
+	webhook.url = url
+	webhook.template = tmpl
+}
+
+// currentWebhook returns the webhook URL and payload template.
+func currentWebhook() (string, *template.Template) {
+	webhook.mu.Lock()
+	defer webhook.mu.Unlock()
+
+	return webhook.url, webhook.template
+}
+
+// webhookEvent is a server lifecycle event posted to the webhook.
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	Server    string    `json:"server,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	ExitCode  *int      `json:"exit_code,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// CrashReport is the start of the crash report written by a crash, if
+	// -webhook-crash-report is set.
+	CrashReport string `json:"crash_report,omitempty"`
+}
+
+// parseWebhookTemplate parses a webhook payload template. The template is
+// executed with a webhookEvent, and may use the json function to encode a
+// value as JSON, e.g. {"content": {{json .Event}}}.
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(v); err != nil {
+				return "", err
+			}
+			return strings.TrimSuffix(buf.String(), "\n"), nil
+		},
+	}).Parse(text)
+}
+
+// notify posts the given lifecycle event of the server to the webhook, if
+// one is configured. The exit code is included for events where the server
+// has exited. Failures are logged rather than returned so that they never
+// affect the server.
+func (s *server) notify(event string, exitCode *int) {
+	s.notifyEvent(webhookEvent{Event: event, ExitCode: exitCode})
+}
+
+// notifyEvent posts the given event of the server to the webhook, if one is
+// configured, filling in the server and the time.
+func (s *server) notifyEvent(event webhookEvent) {
+	url, tmpl := currentWebhook()
+	if url == "" {
+		return
+	}
+
+	event.Server = s.name
+	event.Version = s.version
+	event.Timestamp = time.Now().UTC()
+	if err := postWebhook(url, tmpl, event); err != nil {
+		s.logf("failed to post %s event to webhook: %v", event.Event, err)
+	}
+}
+
+// postWebhook posts the given event to the webhook at url, shaped by the
+// template if it isn't nil.
+func postWebhook(url string, tmpl *template.Template, event webhookEvent) error {
+	var body bytes.Buffer
+	if tmpl != nil {
+		if err := tmpl.Execute(&body, event); err != nil {
+			return err
+		}
+	} else if err := json.NewEncoder(&body).Encode(event); err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Post(url, "application/json", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Services such as Discord reply with 204 No Content.
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// wrapperLog writes the wrapper's own log messages as JSON lines, or is nil
+// for the plain log format.
+var wrapperLog *jsonLogWriter
+
+// jsonLogWriter turns each message written by the log package into a JSON
+// line with a time and a level.
+type jsonLogWriter struct {
+	w io.Writer
+}
+
+// jsonLogEntry is a wrapper log message in the JSON log format.
+type jsonLogEntry struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+}
+
+// setLogFormat switches the wrapper's own logging, but not the server's
+// output, to the given format, which is plain or json.
+func setLogFormat(format string) error {
+	switch format {
+	case "plain":
+		wrapperLog = nil
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	case "json":
+		wrapperLog = &jsonLogWriter{w: os.Stderr}
+		log.SetFlags(0)
+		log.SetOutput(wrapperLog)
+	default:
+		return fmt.Errorf("invalid log format %q: must be 'plain' or 'json'", format)
+	}
+
+	return nil
+}
+
+// Write emits a message from the log package, which writes each message in
+// a single call. Messages starting with "warning: " are logged at the warn
+// level and the rest at the info level.
+func (l *jsonLogWriter) Write(b []byte) (int, error) {
+	msg := strings.TrimSuffix(string(b), "\n")
+	level := "info"
+	if rest, ok := strings.CutPrefix(msg, "warning: "); ok {
+		level, msg = "warn", rest
+	}
+
+	if err := l.emit(level, msg); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// emit writes a message at the given level as a JSON line.
+func (l *jsonLogWriter) emit(level, msg string) error {
+	data, err := json.Marshal(jsonLogEntry{
+		Time:  time.Now().UTC().Format(time.RFC3339Nano),
+		Level: level,
+		Msg:   msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = l.w.Write(append(data, '\n'))
+	return err
+}
+
+// logError logs the error that made the wrapper fail, at the error level in
+// the JSON log format.
+func logError(err error) {
+	if wrapperLog != nil {
+		wrapperLog.emit("error", err.Error())
+		return
+	}
+
+	log.Print(err)
+}